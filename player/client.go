@@ -2,235 +2,551 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"pokemonproject/player/pokeclient"
+	"pokemonproject/pokeconfig"
 )
 
-type Pokemon struct {
-	ID               int              `json:"id"`
-	Name             string           `json:"name"`
-	DAMAGE_RELATIONS DamageRelation   `json:"damage_relations"`
-	POKEMON          []PokemonItem    `json:"pokemon"`
-}
+// Protocol types live in pokeclient, shared with bots and tests
+type (
+	Pokemon       = pokeclient.Pokemon
+	PokemonDetail = pokeclient.PokemonDetail
+)
 
-type DamageRelation struct {
-	DOUBLE_DAMAGE_FROM []DamageRelationItem `json:"double_damage_from"`
-	DOUBLE_DAMAGE_TO   []DamageRelationItem `json:"double_damage_to"`
-	HALF_DAMAGE_FROM   []DamageRelationItem `json:"half_damage_from"`
-	HALF_DAMAGE_TO     []DamageRelationItem `json:"half_damage_to"`
-}
+// Address of the game server
+var SERVER_ADDR = "localhost:8080"
 
-type DamageRelationItem struct {
-	NAME string `json:"name"`
-	URL  string `json:"url"`
-}
+// Numbered prompts instead of the full screen team builder
+var PLAIN = false
 
-type PokemonItem struct {
-	POKEMON_DETAIL PokemonDetail `json:"pokemon"`
-}
+func main() {
+	configFile := flag.String("config", "client.yaml", "YAML config file, may be missing unless set explicitly")
+	flag.StringVar(&SERVER_ADDR, "addr", SERVER_ADDR, "address of the game server")
+	flag.StringVar(&SESSION_FILE, "session", SESSION_FILE, "file keeping the session token")
+	flag.BoolVar(&PLAIN, "plain", PLAIN, "use numbered prompts instead of the full screen team builder")
+	flag.StringVar(&SCRIPT_FILE, "script", SCRIPT_FILE, "run the commands of a script file without prompts, - for stdin")
+	flag.StringVar(&NAME, "name", NAME, "account name for -type, the saved session is resumed without it")
+	flag.StringVar(&PASSWORD, "password", PASSWORD, "account password for -type, better set as POKEMON_CLIENT_PASSWORD")
+	flag.BoolVar(&REGISTER, "register", REGISTER, "register the account of -name instead of logging in")
+	flag.StringVar(&TEAM_TYPE, "type", TEAM_TYPE, "choose a team of this type without prompts, with -pick")
+	flag.StringVar(&TEAM_PICKS, "pick", TEAM_PICKS, "numbers of the Pokémon of -type to pick, e.g. 1,4,7")
+	flag.Parse()
 
-type PokemonDetail struct {
-	NAME  string         `json:"name"`
-	URL   string         `json:"url"`
-	STATS []PokemonStats `json:"stats"`
-  TYPES []PokemonType `json:"types"`
-}
+	if _, err := pokeconfig.Load(*configFile, "POKEMON_CLIENT_"); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if SERVER_ADDR == "" || SESSION_FILE == "" {
+		log.Fatalf("Invalid config: -addr and -session are required")
+	}
+	if (TEAM_TYPE == "") != (TEAM_PICKS == "") {
+		log.Fatalf("Invalid config: -type and -pick go together")
+	}
 
-type PokemonStats struct {
-	BaseStat int `json:"base_stat"`
-	Effort   int `json:"effort"`
-	Stat     struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
-	} `json:"stat"`
-}
+	// Bots and tests run a script, or the team flags, without prompts
+	if SCRIPT_FILE != "" || TEAM_TYPE != "" {
+		if err := runHeadless(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// The team builder needs a terminal, piped input gets the prompts
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		PLAIN = true
+	}
 
-type PokemonType struct {
-    TYPE struct {
-        Name string `json:"name"`
-		URL  string `json:"url"`
-    } `json:"type"`
+	// Reconnect with the saved session when the connection drops
+	reader := bufio.NewReader(os.Stdin)
+	for attempt := 1; ; attempt++ {
+		err := play(reader)
+		if err == nil || errors.Is(err, errCancelled) {
+			return
+		}
+		if !errors.Is(err, errDisconnected) || attempt > MAX_RECONNECTS {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v, reconnecting in %s...\n", err, RECONNECT_DELAY*time.Duration(attempt))
+		time.Sleep(RECONNECT_DELAY * time.Duration(attempt))
+	}
 }
 
-// User struct to store user information and their selected Pokémon
-type User struct {
-	Name     string
-	TypeOfPokemon       string
-	Selected []PokemonDetail // Map to store selected Pokémon by type
+// How often and how patiently the client reconnects
+const MAX_RECONNECTS = 5
+const RECONNECT_DELAY = 2 * time.Second
+
+// Errors of the connection, as opposed to the player's input
+var errDisconnected = errors.New("connection lost")
+
+// The player left before finishing
+var errCancelled = errors.New("cancelled")
+
+func disconnected(what string, err error) error {
+	return fmt.Errorf("%w: %s: %v", errDisconnected, what, err)
 }
 
-func main() {
+// play runs one connection, from login to quitting
+func play(reader *bufio.Reader) error {
 	// Connect to server
-	conn, err := net.Dial("tcp", "localhost:8080")
+	client, err := pokeclient.Connect(SERVER_ADDR)
 	if err != nil {
-		log.Fatalf("Failed to connect to server: %v", err)
+		return disconnected("failed to connect to server", err)
 	}
-	defer conn.Close()
+	defer client.Close()
+	client.OnEvent(func(event pokeclient.Event) {
+		notifyMu.Lock()
+		show := notify
+		notifyMu.Unlock()
+		show(event.Message)
+	})
 
-	// Get client name
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter your name: ")
-	clientName, _ := reader.ReadString('\n')
-
-	// Send client name to server
-	_, err = conn.Write([]byte(clientName))
+	// Log in before anything else
+	auth, err := authenticate(client, reader)
 	if err != nil {
-		log.Fatalf("Failed to send client name: %v", err)
+		return disconnected("failed to log in", err)
+	}
+	clientName := auth.Name
+	fmt.Printf("Logged in as %s\n", clientName)
+
+	// A resumed session may already have its team
+	if auth.Resumed != nil && auth.Resumed.Team != nil {
+		fmt.Printf("Welcome back, your %s team:\n", auth.Resumed.Team.TypeOfPokemon)
+		for i, p := range auth.Resumed.Team.Selected {
+			fmt.Printf("%d. %s\n", i+1, p.NAME)
+		}
+		return presence(client, reader)
 	}
 
 	/*  */
 	// Read the available Pokémon data from the server
-	pokemonData, err := readPokemonData(conn)
+	pokemonData, err := client.Offer()
+	if err != nil {
+		return disconnected("failed to read Pokémon data", err)
+	}
+
+	// Select the Pokémon of the team, older servers do not send rules
+	teamSize := 3
+	if auth.Rules != nil {
+		teamSize = auth.Rules.TeamSize
+	}
+
+	var chosenType string
+	var selectedPokemon []PokemonDetail
+	if PLAIN {
+		chosenType, selectedPokemon, err = pickTeamPlain(reader, pokemonData, teamSize)
+	} else {
+		chosenType, selectedPokemon, err = pickTeamTUI(pokemonData, teamSize)
+	}
+	if errors.Is(err, errCancelled) {
+		client.Quit()
+		return nil
+	}
 	if err != nil {
-		log.Fatalf("Failed to read Pokémon data: %v", err)
+		return err
 	}
 
+	// Send the selected Pokémon to the server, which checks the team against
+	// its rules
+	err = client.SaveTeam(pokeclient.Team{
+		Name:          clientName,
+		Selected:      selectedPokemon,
+		TypeOfPokemon: chosenType,
+	})
+	var refused *pokeclient.ServerError
+	if errors.As(err, &refused) {
+		return fmt.Errorf("failed to save your team: %s", refused.Message)
+	}
+	if err != nil {
+		return disconnected("failed to save your team", err)
+	}
+	fmt.Println("Your team is saved")
+
+	return presence(client, reader)
+}
+
+// Function to choose a type and a team with numbered prompts, used when the
+// input is not a terminal
+func pickTeamPlain(reader *bufio.Reader, pokemonData []Pokemon, teamSize int) (string, []PokemonDetail, error) {
 	// Display available types for the user to choose from
 	fmt.Println("Available types:")
-	types := make(map[int]string)
 	for i, p := range pokemonData {
 		fmt.Printf("%d. %s\n", i+1, p.Name)
-		types[i+1] = p.Name
 	}
 
 	// Get the user's choice
-	fmt.Print("Enter the number of your chosen type: ")
-	choiceStr, _ := reader.ReadString('\n')
-	choiceStr = strings.TrimSpace(choiceStr)
-	choice, err := strconv.Atoi(choiceStr)
-	if err != nil || choice < 1 || choice > len(pokemonData) {
-		log.Fatalf("Invalid choice: %v", choiceStr)
+	choice, err := promptNumber(reader, "Enter the number of your chosen type: ", len(pokemonData))
+	if err != nil {
+		return "", nil, err
 	}
 
 	// Store the chosen type
-	chosenType := types[choice]
+	chosenType := pokemonData[choice-1].Name
 	fmt.Printf("You have chosen: %s\n", chosenType)
 
 	// Get the Pokémon associated with the chosen type
-	chosenPokemon := getPokemonByType(pokemonData, chosenType)
-	// fmt.Println(chosenPokemon)
+	chosenPokemon := pokeclient.PokemonOfType(pokemonData, chosenType)
+	if len(chosenPokemon) == 0 {
+		return "", nil, fmt.Errorf("no Pokémon of type %s on offer", chosenType)
+	}
 
 	// Print the available Pokémon for the user to choose from
 	fmt.Println("Available Pokémon:")
 	for i, p := range chosenPokemon {
-		fmt.Printf("%d. %s\n", i+1, p.NAME)
+		fmt.Printf("%d. %-20s %s\n", i+1, p.NAME, statLine(p))
 	}
 
-	// Select 3 Pokémon
 	var selectedPokemon []PokemonDetail
+	for len(selectedPokemon) < teamSize {
+		prompt := fmt.Sprintf("Enter the number of your %dth chosen Pokémon: ", len(selectedPokemon)+1)
+		pokemonChoice, err := promptNumber(reader, prompt, len(chosenPokemon))
+		if err != nil {
+			return "", nil, err
+		}
+		picked := chosenPokemon[pokemonChoice-1]
+		if hasPokemon(selectedPokemon, picked.NAME) {
+			fmt.Printf("%s is already in your team, pick another one\n", picked.NAME)
+			continue
+		}
+		selectedPokemon = append(selectedPokemon, picked)
 
-	for i := 0; i < 3; i++ {
-		fmt.Printf("Enter the number of your %dth chosen Pokémon: ", i+1)
-		pokemonChoiceStr, _ := reader.ReadString('\n')
-		pokemonChoiceStr = strings.TrimSpace(pokemonChoiceStr)
-		pokemonChoice, err := strconv.Atoi(pokemonChoiceStr)
-		if err != nil || pokemonChoice < 1 || pokemonChoice > len(chosenPokemon) {
-			log.Fatalf("Invalid choice: %v", pokemonChoiceStr)
+		// Show what the team covers so far
+		report := pokeclient.TeamCoverage(pokemonData, selectedPokemon)
+		fmt.Printf("Super effective against: %s\n", joinOrNone(report.Hits))
+		fmt.Printf("Shared weaknesses: %s\n", joinOrNone(report.SharedWeaknesses()))
+		if len(report.Unknown) > 0 {
+			fmt.Printf("No damage data for: %s\n", strings.Join(report.Unknown, ", "))
 		}
+	}
+	return chosenType, selectedPokemon, nil
+}
 
-		selectedPokemon = append(selectedPokemon, chosenPokemon[pokemonChoice-1])
+// Function to print the base stats of a Pokémon on one line
+func statLine(p PokemonDetail) string {
+	var parts []string
+	total := 0
+	for _, stat := range STAT_LABELS {
+		value := baseStat(p, stat.Name)
+		total += value
+		parts = append(parts, fmt.Sprintf("%s %3d", stat.Label, value))
 	}
+	return fmt.Sprintf("%s (Tot %d)", strings.Join(parts, " "), total)
+}
 
-	// Store the selected Pokémon in the user's data
-	user := User{
-		Name: clientName, 
-		Selected: selectedPokemon, 
-		TypeOfPokemon: chosenType,
+func hasPokemon(team []PokemonDetail, name string) bool {
+	for _, p := range team {
+		if p.NAME == name {
+			return true
+		}
 	}
+	return false
+}
 
-	// Print selected Pokémon for each user
-	// fmt.Printf("NAME: %s", user.Name)
-	// fmt.Printf("TYPE: %s", user.TypeOfPokemon)
-	// fmt.Println("LIST OF POKEMON:", formatDataReadable(user.Selected))
+func joinOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
 
-	// Send the selected Pokémon to the server
-	userSelectedPokemonJSON, err := json.Marshal(user)
-	if err != nil {
-		log.Fatalf("Failed to marshal selected Pokémon: %v", err)
+// Function to read a number from 1 to max, asking again on invalid input
+func promptNumber(reader *bufio.Reader, prompt string, max int) (int, error) {
+	for {
+		fmt.Print(prompt)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return 0, errCancelled
+		}
+		choice, convErr := strconv.Atoi(strings.TrimSpace(line))
+		if convErr == nil && choice >= 1 && choice <= max {
+			return choice, nil
+		}
+		fmt.Printf("Please enter a number from 1 to %d\n", max)
 	}
+}
 
-	/*  */
-	// Write the length of the JSON output first
-	jsonLength := int32(len(userSelectedPokemonJSON))
-	err = binary.Write(conn, binary.LittleEndian, jsonLength)
-	if err != nil {
-		log.Fatalf("Failed to write length: %v", err)
+// Function to list online players until the player quits
+func presence(client *pokeclient.Client, reader *bufio.Reader) error {
+	for {
+		fmt.Print("Type 'who' to see who is online, or press enter to quit: ")
+		command, _ := reader.ReadString('\n')
+		if strings.TrimSpace(command) != "who" {
+			client.Quit()
+			return nil
+		}
+
+		online, err := client.Presence()
+		if err != nil {
+			return disconnected("failed to read online players", err)
+		}
+		for i, u := range online {
+			fmt.Printf("%d. %s (%s, since %s)\n", i+1, u.Name, u.State, u.ConnectedAt.Local().Format("15:04"))
+		}
 	}
-	/*  */
+}
 
-	_, err = conn.Write(userSelectedPokemonJSON)
-	if err != nil {
-		log.Fatalf("Failed to send selected Pokémon to server: %v", err)
+// Token of the last session, used to log in again without a password
+var SESSION_FILE = ".session"
+
+// Function to log in, register or resume the saved session
+func authenticate(client *pokeclient.Client, reader *bufio.Reader) (pokeclient.AuthResponse, error) {
+	var refused *pokeclient.ServerError
+	if token, err := os.ReadFile(SESSION_FILE); err == nil {
+		response, err := client.Resume(strings.TrimSpace(string(token)))
+		if err == nil {
+			return response, saveSession(response.Token)
+		}
+		if !errors.As(err, &refused) {
+			return response, err
+		}
+		fmt.Println(refused.Message)
 	}
 
-	/* Start game */
-	fmt.Print("Do you want to start your game: ")
-	isStart, _ := reader.ReadString('\n')
+	for {
+		choice, err := promptNumber(reader, "1. Login\n2. Register\nEnter your choice: ", 2)
+		if err != nil {
+			return pokeclient.AuthResponse{}, err
+		}
+		request := pokeclient.AuthRequest{Action: "login"}
+		if choice == 2 {
+			request.Action = "register"
+		}
+
+		fmt.Print("Enter your name: ")
+		request.Name, _ = reader.ReadString('\n')
+		request.Name = strings.TrimSpace(request.Name)
+		fmt.Print("Enter your password: ")
+		request.Password, _ = reader.ReadString('\n')
+		request.Password = strings.TrimRight(request.Password, "\r\n")
 
-	if strings.Contains(isStart, "yes") {
-		fmt.Print("Please enter your name: ")
-		name, _ := reader.ReadString('\n')
-		_, err = conn.Write([]byte(name))
+		response, err := client.Authenticate(request)
+		if err == nil {
+			return response, saveSession(response.Token)
+		}
+		if !errors.As(err, &refused) {
+			return response, err
+		}
+		fmt.Println(refused.Message)
+	}
+}
+
+func saveSession(token string) error {
+	return os.WriteFile(SESSION_FILE, []byte(token), 0600)
+}
+
+// notify shows a message of the server, the team builder replaces it while
+// it owns the screen
+var notify = func(message string) {
+	fmt.Printf("\n[server] %s\n", message)
+}
+var notifyMu sync.Mutex
+
+func setNotify(f func(message string)) func(message string) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	previous := notify
+	notify = f
+	return previous
+}
+
+/* Scripted mode */
+
+// Headless mode for bots and tests
+var (
+	SCRIPT_FILE = ""
+	NAME        = ""
+	PASSWORD    = ""
+	REGISTER    = false
+	TEAM_TYPE   = ""
+	TEAM_PICKS  = ""
+)
+
+// Function to run the script file, or a script made of the team flags
+func runHeadless() error {
+	var script io.Reader
+	switch SCRIPT_FILE {
+	case "":
+		script = strings.NewReader(flagScript())
+	case "-":
+		script = os.Stdin
+	default:
+		file, err := os.Open(SCRIPT_FILE)
 		if err != nil {
-			log.Fatalf("Failed to send client name: %v", err)
+			return err
 		}
+		defer file.Close()
+		script = file
 	}
-	/* End */
+	return runScript(script, os.Stdout)
 }
 
-// Function to read the Pokémon data from the server
-func readPokemonData(conn net.Conn) ([]Pokemon, error) {
-	var length int32
-	err := binary.Read(conn, binary.LittleEndian, &length)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read length: %v", err)
+func flagScript() string {
+	var lines []string
+	switch {
+	case NAME == "":
+		lines = append(lines, "resume")
+	case REGISTER:
+		lines = append(lines, "register "+NAME+" "+PASSWORD)
+	default:
+		lines = append(lines, "login "+NAME+" "+PASSWORD)
 	}
+	lines = append(lines, "team "+TEAM_TYPE+" "+TEAM_PICKS, "quit")
+	return strings.Join(lines, "\n")
+}
 
-	jsonData := make([]byte, length)
-	_, err = io.ReadFull(conn, jsonData)
+// runScript runs one command per line on a single connection and prints one
+// line per result. Blank lines and lines starting with # are skipped.
+//
+//	register NAME PASSWORD
+//	login NAME PASSWORD
+//	resume [TOKEN]        the saved session without a token
+//	offer                 list the offered Pokémon, numbered for team
+//	team TYPE PICKS       e.g. team fire 1,4,7
+//	presence
+//	sleep DURATION        e.g. sleep 500ms
+//	quit
+func runScript(script io.Reader, out io.Writer) error {
+	client, err := pokeclient.Connect(SERVER_ADDR)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read JSON data: %v", err)
+		return fmt.Errorf("failed to connect to server: %w", err)
 	}
+	defer client.Close()
+	client.OnEvent(func(event pokeclient.Event) {
+		fmt.Fprintf(out, "event %s: %s\n", event.Event, event.Message)
+	})
 
-	var pokemonData []Pokemon
-	err = json.Unmarshal(jsonData, &pokemonData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON data: %v", err)
+	scanner := bufio.NewScanner(script)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := runCommand(client, line, out); err != nil {
+			return fmt.Errorf("line %d: %s: %w", number, line, err)
+		}
+		if line == "quit" {
+			return nil
+		}
 	}
+	return scanner.Err()
+}
 
-	// fmt.Println(pokemonData)
+func runCommand(client *pokeclient.Client, line string, out io.Writer) error {
+	// Passwords may contain spaces, they take the rest of the line
+	args := strings.SplitN(line, " ", 3)
+	switch {
+	case (args[0] == "login" || args[0] == "register") && len(args) == 3:
+		response, err := client.Authenticate(pokeclient.AuthRequest{Action: args[0], Name: args[1], Password: args[2]})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ok %s %s\n", args[0], response.Name)
+		return saveSession(response.Token)
 
-	// // Marshal the JSON object to pretty-print it
-	// jsonOutput, err := json.MarshalIndent(pokemonData, "", "  ")
-	// if err != nil {
-	// 	fmt.Println("could not marshal JSON")
-	// }
+	case args[0] == "resume" && len(args) <= 2:
+		var token string
+		if len(args) == 2 {
+			token = args[1]
+		} else {
+			saved, err := os.ReadFile(SESSION_FILE)
+			if err != nil {
+				return err
+			}
+			token = strings.TrimSpace(string(saved))
+		}
+		response, err := client.Resume(token)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ok resume %s\n", response.Name)
+		if response.Resumed != nil && response.Resumed.Team != nil {
+			fmt.Fprintf(out, "team %s: %s\n", response.Resumed.Team.TypeOfPokemon, teamNames(response.Resumed.Team.Selected))
+		}
+		return saveSession(response.Token)
 
-	// // Print the JSON output to the terminal
-	// fmt.Println(string(jsonOutput))
+	case line == "offer":
+		offer, err := client.Offer()
+		if err != nil {
+			return err
+		}
+		for _, t := range offer {
+			var numbered []string
+			for i, p := range pokeclient.PokemonOfType(offer, t.Name) {
+				numbered = append(numbered, fmt.Sprintf("%d %s", i+1, p.NAME))
+			}
+			fmt.Fprintf(out, "offer %s: %s\n", t.Name, strings.Join(numbered, ", "))
+		}
+		return nil
 
-	return pokemonData, nil
+	case args[0] == "team" && len(args) == 3:
+		picks, err := parsePicks(args[2])
+		if err != nil {
+			return err
+		}
+		team, err := client.ChooseTeam(args[1], picks)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "team %s: %s\n", team.TypeOfPokemon, teamNames(team.Selected))
+		return nil
+
+	case line == "presence":
+		online, err := client.Presence()
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, u := range online {
+			names = append(names, fmt.Sprintf("%s (%s)", u.Name, u.State))
+		}
+		fmt.Fprintf(out, "online %s\n", joinOrNone(names))
+		return nil
+
+	case args[0] == "sleep" && len(args) == 2:
+		delay, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		time.Sleep(delay)
+		return nil
+
+	case line == "quit":
+		return client.Quit()
+	}
+	return fmt.Errorf("unknown command")
 }
 
-// Function to get Pokémon by type
-func getPokemonByType(pokemonData []Pokemon, typeName string) []PokemonDetail {
-	var pokemon []PokemonDetail
-	for _, p := range pokemonData {
-		if p.Name == typeName {
-			for _, pd := range p.POKEMON {
-				pokemon = append(pokemon, pd.POKEMON_DETAIL)
-			}
-			break
+// Function to parse picks like 1,4,7
+func parsePicks(list string) ([]int, error) {
+	var picks []int
+	for _, item := range strings.Split(list, ",") {
+		pick, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pick %q", item)
 		}
+		picks = append(picks, pick)
+	}
+	return picks, nil
+}
+
+func teamNames(team []PokemonDetail) string {
+	var names []string
+	for _, p := range team {
+		names = append(names, p.NAME)
 	}
-	return pokemon
+	return strings.Join(names, ", ")
 }
 
 func formatDataReadable(data any) any {
@@ -243,4 +559,480 @@ func formatDataReadable(data any) any {
 	// Print the JSON output to the terminal
 	// fmt.Println(string(jsonOutput))
 	return string(jsonOutput)
-}
\ No newline at end of file
+}
+/* Team builder */
+
+// Colors of the type badges
+var TYPE_COLORS = map[string]string{
+	"normal": "#A8A77A", "fire": "#EE8130", "water": "#6390F0", "electric": "#F7D02C",
+	"grass": "#7AC74C", "ice": "#96D9D6", "fighting": "#C22E28", "poison": "#A33EA1",
+	"ground": "#E2BF65", "flying": "#A98FF3", "psychic": "#F95587", "bug": "#A6B91A",
+	"rock": "#B6A136", "ghost": "#735797", "dragon": "#6F35FC", "dark": "#705746",
+	"steel": "#B7B7CE", "fairy": "#D685AD",
+}
+
+// Stats in the order they are shown
+var STAT_LABELS = []struct{ Name, Label string }{
+	{"hp", "HP"}, {"attack", "Atk"}, {"defense", "Def"},
+	{"special-attack", "SpA"}, {"special-defense", "SpD"}, {"speed", "Spe"},
+}
+
+const statBarWidth = 20
+
+// Highest base stat, bars are scaled to it
+const maxBaseStat = 255
+
+var (
+	titleStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFCB05"))
+	cursorStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFFFF")).Background(lipgloss.Color("#3D7DCA"))
+	dimStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#888888"))
+	pickedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#7AC74C"))
+	higherStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#7AC74C"))
+	lowerStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#E3350D"))
+	messageStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFCB05"))
+	panelStyle   = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+)
+
+const (
+	screenTypes = iota
+	screenPokemon
+)
+
+// serverMessage is an event of the server shown inside the team builder
+type serverMessage string
+
+type teamPicker struct {
+	offer    []Pokemon
+	teamSize int
+
+	screen     int
+	typeCursor int
+
+	chosenType string
+	pokemon    []PokemonDetail
+	filtered   []int // indexes into pokemon that match the search
+	cursor     int   // index into filtered
+	offset     int   // first visible row of filtered
+
+	searching bool
+	search    string
+
+	compare int   // index into pokemon, -1 for none
+	team    []int // indexes into pokemon
+
+	message string
+	width   int
+	height  int
+
+	saved     bool
+	cancelled bool
+}
+
+// Function to choose a type and a team in a full screen terminal UI
+func pickTeamTUI(pokemonData []Pokemon, teamSize int) (string, []PokemonDetail, error) {
+	picker := &teamPicker{offer: pokemonData, teamSize: teamSize, compare: -1, height: 24, width: 80}
+	program := tea.NewProgram(picker, tea.WithAltScreen())
+
+	previous := setNotify(func(message string) {
+		program.Send(serverMessage(message))
+	})
+	defer setNotify(previous)
+
+	if _, err := program.Run(); err != nil {
+		return "", nil, fmt.Errorf("team builder failed: %w", err)
+	}
+	if picker.cancelled || !picker.saved {
+		return "", nil, errCancelled
+	}
+
+	var team []PokemonDetail
+	for _, i := range picker.team {
+		team = append(team, picker.pokemon[i])
+	}
+	return picker.chosenType, team, nil
+}
+
+func (m *teamPicker) Init() tea.Cmd {
+	return nil
+}
+
+func (m *teamPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+		return m, nil
+	case serverMessage:
+		m.message = "[server] " + string(msg)
+		return m, nil
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			m.cancelled = true
+			return m, tea.Quit
+		}
+		if m.screen == screenTypes {
+			return m.updateTypes(msg)
+		}
+		if m.searching {
+			return m.updateSearch(msg)
+		}
+		return m.updatePokemon(msg)
+	}
+	return m, nil
+}
+
+func (m *teamPicker) updateTypes(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "esc":
+		m.cancelled = true
+		return m, tea.Quit
+	case "up", "k":
+		if m.typeCursor > 0 {
+			m.typeCursor--
+		}
+	case "down", "j":
+		if m.typeCursor < len(m.offer)-1 {
+			m.typeCursor++
+		}
+	case "enter", " ":
+		if len(m.offer) == 0 {
+			return m, nil
+		}
+		chosen := m.offer[m.typeCursor].Name
+		pokemon := pokeclient.PokemonOfType(m.offer, chosen)
+		if len(pokemon) == 0 {
+			m.message = fmt.Sprintf("No Pokémon of type %s on offer", chosen)
+			return m, nil
+		}
+		m.chosenType = chosen
+		m.pokemon = pokemon
+		m.team = nil
+		m.compare = -1
+		m.search = ""
+		m.applySearch()
+		m.message = ""
+		m.screen = screenPokemon
+	}
+	return m, nil
+}
+
+func (m *teamPicker) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter, tea.KeyEsc:
+		m.searching = false
+	case tea.KeyBackspace:
+		if m.search != "" {
+			runes := []rune(m.search)
+			m.search = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes:
+		m.search += string(msg.Runes)
+	case tea.KeySpace:
+		m.search += " "
+	}
+	m.applySearch()
+	return m, nil
+}
+
+func (m *teamPicker) updatePokemon(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.message = ""
+	switch msg.String() {
+	case "q":
+		m.cancelled = true
+		return m, tea.Quit
+	case "esc", "backspace":
+		// Back to the types, the team is for one type only
+		m.screen = screenTypes
+		m.team = nil
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.listHeight())
+	case "pgdown":
+		m.move(m.listHeight())
+	case "home", "g":
+		m.move(-len(m.filtered))
+	case "end", "G":
+		m.move(len(m.filtered))
+	case "/":
+		m.searching = true
+	case "c":
+		if current, ok := m.current(); ok {
+			if m.compare == current {
+				m.compare = -1
+			} else {
+				m.compare = current
+			}
+		}
+	case "enter", " ":
+		if current, ok := m.current(); ok {
+			m.togglePick(current)
+		}
+	case "s":
+		if len(m.team) < m.teamSize {
+			m.message = fmt.Sprintf("Pick %d more Pokémon first", m.teamSize-len(m.team))
+			return m, nil
+		}
+		m.saved = true
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m *teamPicker) togglePick(index int) {
+	for i, picked := range m.team {
+		if picked == index {
+			m.team = append(m.team[:i], m.team[i+1:]...)
+			return
+		}
+	}
+	for _, picked := range m.team {
+		if m.pokemon[picked].NAME == m.pokemon[index].NAME {
+			m.message = fmt.Sprintf("%s is already in your team", m.pokemon[index].NAME)
+			return
+		}
+	}
+	if len(m.team) >= m.teamSize {
+		m.message = fmt.Sprintf("Your team already has %d Pokémon, remove one first", m.teamSize)
+		return
+	}
+	m.team = append(m.team, index)
+	if len(m.team) == m.teamSize {
+		m.message = "Team complete, press s to save it"
+	}
+}
+
+func (m *teamPicker) current() (int, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.filtered) {
+		return 0, false
+	}
+	return m.filtered[m.cursor], true
+}
+
+func (m *teamPicker) move(delta int) {
+	m.cursor += delta
+	if m.cursor >= len(m.filtered) {
+		m.cursor = len(m.filtered) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	m.scroll()
+}
+
+// scroll keeps the cursor inside the visible rows
+func (m *teamPicker) scroll() {
+	height := m.listHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+}
+
+// coverageView shows the team's super effective hits and shared weaknesses
+func (m *teamPicker) coverageView(team []PokemonDetail) string {
+	if len(team) == 0 {
+		return dimStyle.Render("Pick Pokémon to see the team's type coverage") + "\n\n"
+	}
+	report := pokeclient.TeamCoverage(m.offer, team)
+
+	var hits []string
+	for _, name := range report.Hits {
+		hits = append(hits, typeBadge(name))
+	}
+	line := "Super effective: " + dimStyle.Render("none")
+	if len(hits) > 0 {
+		line = "Super effective: " + strings.Join(hits, " ")
+	}
+
+	weak := "Shared weaknesses: " + dimStyle.Render("none")
+	if shared := report.SharedWeaknesses(); len(shared) > 0 {
+		weak = "Shared weaknesses: " + lowerStyle.Render(strings.Join(shared, ", "))
+	}
+	if len(report.Unknown) > 0 {
+		weak += dimStyle.Render("  (no data for " + strings.Join(report.Unknown, ", ") + ")")
+	}
+	return line + "\n" + weak + "\n"
+}
+
+// listHeight is what is left of the screen for the species list
+func (m *teamPicker) listHeight() int {
+	height := m.height - 10
+	if height < 3 {
+		height = 3
+	}
+	return height
+}
+
+func (m *teamPicker) applySearch() {
+	query := strings.ToLower(strings.TrimSpace(m.search))
+	m.filtered = m.filtered[:0]
+	for i, p := range m.pokemon {
+		if query == "" || strings.Contains(strings.ToLower(p.NAME), query) {
+			m.filtered = append(m.filtered, i)
+		}
+	}
+	m.cursor = 0
+	m.offset = 0
+}
+
+func (m *teamPicker) inTeam(index int) bool {
+	for _, picked := range m.team {
+		if picked == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *teamPicker) View() string {
+	var b strings.Builder
+	if m.screen == screenTypes {
+		b.WriteString(titleStyle.Render("Choose a type") + "\n\n")
+		for i, p := range m.offer {
+			line := fmt.Sprintf("%s %s", typeBadge(p.Name), dimStyle.Render(fmt.Sprintf("%d Pokémon", len(p.POKEMON))))
+			if i == m.typeCursor {
+				line = cursorStyle.Render("▸") + " " + line
+			} else {
+				line = "  " + line
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("\n" + dimStyle.Render("↑/↓ move • enter choose • q quit") + "\n")
+		if m.message != "" {
+			b.WriteString(messageStyle.Render(m.message) + "\n")
+		}
+		return b.String()
+	}
+
+	title := fmt.Sprintf("Build your %s team", m.chosenType)
+	b.WriteString(titleStyle.Render(title) + "  " + typeBadge(m.chosenType) + "\n")
+
+	// Species list on the left, details and comparison on the right
+	panels := []string{m.listView()}
+	if current, ok := m.current(); ok {
+		panels = append(panels, panelStyle.Render(detailView(m.pokemon[current], nil)))
+		if m.compare >= 0 && m.compare != current {
+			other := m.pokemon[current]
+			panels = append(panels, panelStyle.Render(detailView(m.pokemon[m.compare], &other)))
+		}
+	}
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, panels...) + "\n")
+
+	var names []string
+	var team []PokemonDetail
+	for _, i := range m.team {
+		names = append(names, m.pokemon[i].NAME)
+		team = append(team, m.pokemon[i])
+	}
+	b.WriteString(fmt.Sprintf("Team %d/%d: %s\n", len(m.team), m.teamSize, strings.Join(names, ", ")))
+	b.WriteString(m.coverageView(team))
+
+	if m.searching {
+		b.WriteString("/" + m.search + "█\n")
+	} else if m.search != "" {
+		b.WriteString(dimStyle.Render("filter: "+m.search) + "\n")
+	} else {
+		b.WriteString("\n")
+	}
+	b.WriteString(dimStyle.Render("↑/↓ move • enter pick • c compare • / search • s save • esc types • q quit") + "\n")
+	if m.message != "" {
+		b.WriteString(messageStyle.Render(m.message) + "\n")
+	}
+	return b.String()
+}
+
+func (m *teamPicker) listView() string {
+	var rows []string
+	end := m.offset + m.listHeight()
+	if end > len(m.filtered) {
+		end = len(m.filtered)
+	}
+	for row := m.offset; row < end; row++ {
+		index := m.filtered[row]
+		name := m.pokemon[index].NAME
+		mark := "  "
+		if m.inTeam(index) {
+			mark = pickedStyle.Render("✔ ")
+		} else if index == m.compare {
+			mark = dimStyle.Render("≡ ")
+		}
+		if row == m.cursor {
+			name = cursorStyle.Render(name)
+		}
+		rows = append(rows, mark+name)
+	}
+	if len(rows) == 0 {
+		rows = append(rows, dimStyle.Render("no match"))
+	}
+	if len(m.filtered) > m.listHeight() {
+		rows = append(rows, dimStyle.Render(fmt.Sprintf("%d-%d of %d", m.offset+1, end, len(m.filtered))))
+	}
+	return panelStyle.Copy().Width(24).Render(strings.Join(rows, "\n"))
+}
+
+// detailView shows types and stat bars, against is the Pokémon it is compared with
+func detailView(p PokemonDetail, against *PokemonDetail) string {
+	var badges []string
+	for _, t := range p.TYPES {
+		badges = append(badges, typeBadge(t.TYPE.Name))
+	}
+	lines := []string{titleStyle.Render(p.NAME), strings.Join(badges, " "), ""}
+
+	total := 0
+	for _, stat := range STAT_LABELS {
+		value := baseStat(p, stat.Name)
+		total += value
+		filled := value * statBarWidth / maxBaseStat
+		if value > 0 && filled == 0 {
+			filled = 1
+		}
+		bar := strings.Repeat("█", filled) + dimStyle.Render(strings.Repeat("░", statBarWidth-filled))
+		line := fmt.Sprintf("%-3s %3d %s", stat.Label, value, bar)
+		if against != nil {
+			line += " " + statDiff(value-baseStat(*against, stat.Name))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("%-3s %3d", "Tot", total))
+	return strings.Join(lines, "\n")
+}
+
+func statDiff(diff int) string {
+	switch {
+	case diff > 0:
+		return higherStyle.Render(fmt.Sprintf("+%d", diff))
+	case diff < 0:
+		return lowerStyle.Render(fmt.Sprintf("%d", diff))
+	}
+	return dimStyle.Render("=")
+}
+
+func baseStat(p PokemonDetail, name string) int {
+	for _, stat := range p.STATS {
+		if stat.Stat.Name == name {
+			return stat.BaseStat
+		}
+	}
+	return 0
+}
+
+func typeBadge(name string) string {
+	color, ok := TYPE_COLORS[strings.ToLower(name)]
+	if !ok {
+		color = "#68A090"
+	}
+	return lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#FFFFFF")).
+		Background(lipgloss.Color(color)).
+		Padding(0, 1).
+		Render(strings.ToUpper(name))
+}
@@ -2,70 +2,114 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/eiannone/keyboard"
+
+	"pokemonproject/player/pokeclient"
+	"pokemonproject/pokeconfig"
 )
 
 var (
 	consoleLock sync.Mutex
 )
 
-func onMessage(conn net.Conn) {
-	reader := bufio.NewReader(conn)
-	for {
-		msg, _ := reader.ReadString('#')
-
+func onMessage(game *pokeclient.Game) {
+	for msg := range game.Messages() {
 		consoleLock.Lock()
-		fmt.Print(msg[:len(msg)-1])
+		fmt.Print(msg)
 		consoleLock.Unlock()
 	}
 }
 
+// Address of the game server
+var SERVER_ADDR = "localhost:3015"
+
+// Headless mode for bots and tests, the name, password and mode are asked
+// otherwise. The password is the one of the account on server.go, better
+// given as POKEMON_PLAYER_PASSWORD than on the command line.
+var (
+	NAME        = ""
+	PASSWORD    = ""
+	MODE        = 0
+	SCRIPT_FILE = ""
+	SCRIPT_WAIT = 2 * time.Second
+)
+
 func main() {
-	connection, err := net.Dial("tcp", "localhost:3015")
-	if err != nil {
-		log.Fatal(err)
+	configFile := flag.String("config", "player.yaml", "YAML config file, may be missing unless set explicitly")
+	flag.StringVar(&SERVER_ADDR, "addr", SERVER_ADDR, "address of the game server")
+	flag.StringVar(&NAME, "name", NAME, "player name")
+	flag.StringVar(&PASSWORD, "password", PASSWORD, "password of the player's account")
+	flag.IntVar(&MODE, "mode", MODE, "game mode, 1 for POKEBAT or 2 for POKECAT")
+	flag.StringVar(&SCRIPT_FILE, "script", SCRIPT_FILE, "send the actions of a script file instead of reading input, - for stdin")
+	flag.DurationVar(&SCRIPT_WAIT, "wait", SCRIPT_WAIT, "how long to print messages after the script ends")
+	flag.Parse()
+
+	if _, err := pokeconfig.Load(*configFile, "POKEMON_PLAYER_"); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if SERVER_ADDR == "" {
+		log.Fatalf("Invalid config: -addr is required")
+	}
+	if SCRIPT_FILE != "" && (NAME == "" || PASSWORD == "" || MODE == 0) {
+		log.Fatalf("Invalid config: -script needs -name, -password and -mode")
 	}
 
-	fmt.Print("MODE: 1. POKEBAT \t 2. POKECAT\nType following syntax: [Username] [Mode Game]\nYour Input: ")
 	nameReader := bufio.NewReader(os.Stdin)
-	input, _ := nameReader.ReadString('\n')
+	if NAME == "" || MODE == 0 {
+		fmt.Print("MODE: 1. POKEBAT \t 2. POKECAT\nType following syntax: [Username] [Mode Game]\nYour Input: ")
+		input, _ := nameReader.ReadString('\n')
 
-	connection.Write([]byte(input))
+		// separate the name and mode
+		if _, err := fmt.Sscan(input, &NAME, &MODE); err != nil {
+			log.Fatalf("Invalid input %q: %v", strings.TrimSpace(input), err)
+		}
+	}
+	if PASSWORD == "" {
+		fmt.Print("Password: ")
+		input, _ := nameReader.ReadString('\n')
+		PASSWORD = strings.TrimRight(input, "\r\n")
+	}
+
+	game, err := pokeclient.JoinLobby(SERVER_ADDR, NAME, PASSWORD, MODE)
+	if err != nil {
+		log.Fatal(err)
+	}
 	fmt.Println("********** Entered Game **********")
 
-	go onMessage(connection)
+	go onMessage(game)
 
-	// separate the name and mode
-	input = strings.TrimSpace(input)
-	// playerName := strings.Split(input, " ")[0]
-	mode := strings.Split(input, " ")[1]
-	if mode == "1" {
-		for {
-			msgReader := bufio.NewReader(os.Stdin)
+	if SCRIPT_FILE != "" {
+		if err := runScript(game); err != nil {
+			log.Fatal(err)
+		}
+		time.Sleep(SCRIPT_WAIT)
+		return
+	}
 
-			msg, err := msgReader.ReadString('\n')
-			// Remove any CR characters from the input
-			msg = strings.Replace(msg, "\r", "", -1)
+	if MODE == pokeclient.MODE_POKEBAT {
+		for {
+			msg, err := nameReader.ReadString('\n')
 			if err != nil {
 				break
 			}
 
 			consoleLock.Lock()
-			_, err = connection.Write([]byte(msg))
+			err = game.SendAction(msg)
+			consoleLock.Unlock()
 			if err != nil {
 				fmt.Println("Connection closed")
 				break
 			}
-			consoleLock.Unlock()
 		}
-	} else if mode == "2" {
+	} else if MODE == pokeclient.MODE_POKECAT {
 		// go onKeyInput(connection, inputCh)
 
 		for {
@@ -78,10 +122,17 @@ func main() {
 				if event.Err != nil {
 					panic(event.Err)
 				}
-				// Send the key character to the input channel
-				msg := string(rune(event.Key))
+				// Ctrl+C is a key while the keyboard is captured
+				if event.Key == keyboard.KeyCtrlC {
+					keyboard.Close()
+					return
+				}
+				msg := keyName(event)
+				if msg == "" {
+					continue
+				}
 				consoleLock.Lock()
-				_, err := connection.Write([]byte(msg + "\n"))
+				err := game.SendAction(msg)
 				consoleLock.Unlock()
 				// fmt.Println("Sent key press event to server: ", string(event.Key))
 				if err != nil {
@@ -96,3 +147,59 @@ func main() {
 	}
 	// connection.Close()
 }
+
+// keyName is what the server gets for a key: the character of letters and
+// digits, which come as runes, or the name of arrows and Esc
+func keyName(event keyboard.KeyEvent) string {
+	switch event.Key {
+	case keyboard.KeyArrowUp:
+		return "up"
+	case keyboard.KeyArrowDown:
+		return "down"
+	case keyboard.KeyArrowLeft:
+		return "left"
+	case keyboard.KeyArrowRight:
+		return "right"
+	case keyboard.KeyEsc:
+		return "esc"
+	}
+	if event.Rune == 0 {
+		return ""
+	}
+	return string(event.Rune)
+}
+
+// runScript sends one action per line of the script: a command in POKEBAT,
+// a key in POKECAT. "sleep DURATION" pauses, blank lines and lines starting
+// with # are skipped.
+func runScript(game *pokeclient.Game) error {
+	script := os.Stdin
+	if SCRIPT_FILE != "-" {
+		file, err := os.Open(SCRIPT_FILE)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		script = file
+	}
+
+	scanner := bufio.NewScanner(script)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if delay, ok := strings.CutPrefix(line, "sleep "); ok {
+			d, err := time.ParseDuration(delay)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", number, err)
+			}
+			time.Sleep(d)
+			continue
+		}
+		if err := game.SendAction(line); err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,386 @@
+// Package pokeclient talks to the Pokémon servers without a terminal, so the
+// player programs, bots and tests share the same protocol code.
+//
+// Client speaks to the team selection server (server/server.go): log in,
+// read the offer, choose a team and list who is online. Game speaks to the
+// battle server used by player/player.go.
+package pokeclient
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type Pokemon struct {
+	ID               int            `json:"id"`
+	Name             string         `json:"name"`
+	DAMAGE_RELATIONS DamageRelation `json:"damage_relations"`
+	POKEMON          []PokemonItem  `json:"pokemon"`
+}
+
+type DamageRelation struct {
+	DOUBLE_DAMAGE_FROM []DamageRelationItem `json:"double_damage_from"`
+	DOUBLE_DAMAGE_TO   []DamageRelationItem `json:"double_damage_to"`
+	HALF_DAMAGE_FROM   []DamageRelationItem `json:"half_damage_from"`
+	HALF_DAMAGE_TO     []DamageRelationItem `json:"half_damage_to"`
+	NO_DAMAGE_FROM     []DamageRelationItem `json:"no_damage_from"`
+	NO_DAMAGE_TO       []DamageRelationItem `json:"no_damage_to"`
+}
+
+type DamageRelationItem struct {
+	NAME string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type PokemonItem struct {
+	POKEMON_DETAIL PokemonDetail `json:"pokemon"`
+}
+
+type PokemonDetail struct {
+	NAME  string         `json:"name"`
+	URL   string         `json:"url"`
+	STATS []PokemonStats `json:"stats"`
+	TYPES []PokemonType  `json:"types"`
+}
+
+type PokemonStats struct {
+	BaseStat int `json:"base_stat"`
+	Effort   int `json:"effort"`
+	Stat     struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"stat"`
+}
+
+type PokemonType struct {
+	TYPE struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"type"`
+}
+
+// Team is the type and the Pokémon a player picked, as the server saves it
+type Team struct {
+	Name          string
+	TypeOfPokemon string
+	Selected      []PokemonDetail
+}
+
+type AuthRequest struct {
+	Action   string `json:"action"`
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+type AuthResponse struct {
+	OK        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+	AccountID string     `json:"account_id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Token     string     `json:"token,omitempty"`
+	Rules     *TeamRules `json:"rules,omitempty"`
+	Resumed   *Snapshot  `json:"resumed,omitempty"`
+}
+
+type TeamRules struct {
+	TeamSize int  `json:"team_size"`
+	SameType bool `json:"same_type"`
+}
+
+// Snapshot is what the server kept of a resumed session
+type Snapshot struct {
+	State string `json:"state"`
+	Team  *Team  `json:"team"`
+}
+
+type OnlineUser struct {
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+// Messages the server sends on its own
+type Event struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// ServerError is a request the server refused, the connection can still be
+// used. Any other error of a Client means the connection is gone.
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// Client is a connection to the team selection server. It answers the
+// server's pings in the background, so callers can take their time between
+// requests.
+type Client struct {
+	conn    net.Conn
+	frames  chan []byte
+	err     error
+	writeMu sync.Mutex
+
+	eventMu sync.Mutex
+	onEvent func(Event)
+
+	// Auth is the answer to the last successful login, register or resume
+	Auth  AuthResponse
+	offer []Pokemon
+}
+
+// Connect opens a connection to the team selection server at addr
+func Connect(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, frames: make(chan []byte, 16)}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// OnEvent sets the function called with idle warnings and evictions, and
+// returns the previous one
+func (c *Client) OnEvent(f func(Event)) func(Event) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	previous := c.onEvent
+	c.onEvent = f
+	return previous
+}
+
+func (c *Client) Login(name, password string) (AuthResponse, error) {
+	return c.Authenticate(AuthRequest{Action: "login", Name: name, Password: password})
+}
+
+func (c *Client) Register(name, password string) (AuthResponse, error) {
+	return c.Authenticate(AuthRequest{Action: "register", Name: name, Password: password})
+}
+
+// Resume logs in again with the token of an earlier session
+func (c *Client) Resume(token string) (AuthResponse, error) {
+	return c.Authenticate(AuthRequest{Action: "resume", Token: token})
+}
+
+// Authenticate sends a login, register or resume request. A refusal is a
+// *ServerError and may be retried on the same connection.
+func (c *Client) Authenticate(request AuthRequest) (AuthResponse, error) {
+	var response AuthResponse
+	if err := c.request(request, &response); err != nil {
+		return response, err
+	}
+	if !response.OK {
+		return response, &ServerError{response.Error}
+	}
+	c.Auth = response
+
+	// The offer follows, unless a resumed session already has its team
+	c.offer = nil
+	if response.Resumed == nil || response.Resumed.Team == nil {
+		data, err := c.readFrame()
+		if err != nil {
+			return response, err
+		}
+		if err := json.Unmarshal(data, &c.offer); err != nil {
+			return response, fmt.Errorf("failed to unmarshal JSON data: %v", err)
+		}
+	}
+	return response, nil
+}
+
+// Offer returns the Pokémon types the server offered after logging in
+func (c *Client) Offer() ([]Pokemon, error) {
+	if !c.Auth.OK {
+		return nil, fmt.Errorf("not logged in")
+	}
+	if c.offer == nil {
+		return nil, fmt.Errorf("the team is already saved")
+	}
+	return c.offer, nil
+}
+
+// ChooseTeam saves a team of the given type, picks are the 1-based positions
+// of the Pokémon in PokemonOfType
+func (c *Client) ChooseTeam(typeName string, picks []int) (Team, error) {
+	offer, err := c.Offer()
+	if err != nil {
+		return Team{}, err
+	}
+	candidates := PokemonOfType(offer, typeName)
+	if len(candidates) == 0 {
+		return Team{}, fmt.Errorf("no Pokémon of type %s on offer", typeName)
+	}
+	if c.Auth.Rules != nil && len(picks) != c.Auth.Rules.TeamSize {
+		return Team{}, fmt.Errorf("a team has %d Pokémon, got %d picks", c.Auth.Rules.TeamSize, len(picks))
+	}
+
+	team := Team{Name: c.Auth.Name, TypeOfPokemon: typeName}
+	seen := make(map[int]bool)
+	for _, pick := range picks {
+		if pick < 1 || pick > len(candidates) {
+			return Team{}, fmt.Errorf("pick %d is not between 1 and %d", pick, len(candidates))
+		}
+		if seen[pick] {
+			return Team{}, fmt.Errorf("%s is picked twice", candidates[pick-1].NAME)
+		}
+		seen[pick] = true
+		team.Selected = append(team.Selected, candidates[pick-1])
+	}
+	return team, c.SaveTeam(team)
+}
+
+// SaveTeam sends a team to the server, which checks it against its rules
+func (c *Client) SaveTeam(team Team) error {
+	if team.Name == "" {
+		team.Name = c.Auth.Name
+	}
+	var response struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := c.request(team, &response); err != nil {
+		return err
+	}
+	if !response.OK {
+		return &ServerError{response.Error}
+	}
+	c.offer = nil
+	return nil
+}
+
+// Presence asks the server who is online, once the team is saved
+func (c *Client) Presence() ([]OnlineUser, error) {
+	if !c.Auth.OK || c.offer != nil {
+		return nil, fmt.Errorf("choose a team first")
+	}
+	var response struct {
+		Online []OnlineUser `json:"online"`
+		Error  string       `json:"error"`
+	}
+	if err := c.request(map[string]string{"action": "presence"}, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, &ServerError{response.Error}
+	}
+	return response.Online, nil
+}
+
+// Quit tells the server the player is gone for good, it keeps dropped
+// sessions otherwise
+func (c *Client) Quit() error {
+	return c.writeFrame([]byte(`{"action":"quit"}`))
+}
+
+// PokemonOfType lists the offered Pokémon of a type
+func PokemonOfType(offer []Pokemon, typeName string) []PokemonDetail {
+	var pokemon []PokemonDetail
+	for _, p := range offer {
+		if p.Name == typeName {
+			for _, pd := range p.POKEMON {
+				pokemon = append(pokemon, pd.POKEMON_DETAIL)
+			}
+			break
+		}
+	}
+	return pokemon
+}
+
+func (c *Client) request(request any, response any) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if err := c.writeFrame(data); err != nil {
+		return err
+	}
+	data, err = c.readFrame()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, response)
+}
+
+func (c *Client) readLoop() {
+	defer close(c.frames)
+	for {
+		data, err := c.readRawFrame()
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		var event Event
+		if json.Unmarshal(data, &event) == nil && event.Event != "" {
+			switch event.Event {
+			case "ping":
+				c.writeFrame([]byte(`{"action":"pong"}`))
+			default:
+				c.eventMu.Lock()
+				handler := c.onEvent
+				c.eventMu.Unlock()
+				if handler != nil {
+					handler(event)
+				}
+			}
+			continue
+		}
+		c.frames <- data
+	}
+}
+
+// Largest message the client reads, the same limit server.go enforces on
+// the messages of its clients
+const MAX_FRAME_SIZE = 1 << 20
+
+func (c *Client) readRawFrame() ([]byte, error) {
+	var length int32
+	err := binary.Read(c.conn, binary.LittleEndian, &length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read length: %v", err)
+	}
+	if length < 0 || length > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("invalid frame length %d", length)
+	}
+
+	jsonData := make([]byte, length)
+	_, err = io.ReadFull(c.conn, jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON data: %v", err)
+	}
+	return jsonData, nil
+}
+
+// Every message is prefixed by its int32 little endian length
+func (c *Client) writeFrame(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := binary.Write(c.conn, binary.LittleEndian, int32(len(data))); err != nil {
+		return fmt.Errorf("failed to write length: %v", err)
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *Client) readFrame() ([]byte, error) {
+	data, ok := <-c.frames
+	if !ok {
+		return nil, c.err
+	}
+	return data, nil
+}
@@ -0,0 +1,39 @@
+package pokeclient
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadRawFrame(t *testing.T) {
+	tests := []struct {
+		length int32
+		body   string
+		err    string
+	}{
+		{4, "{}{}", ""},
+		{0, "", ""},
+		{-1, "", "invalid frame length -1"},
+		{MAX_FRAME_SIZE + 1, "", "invalid frame length"},
+	}
+	for _, test := range tests {
+		server, conn := net.Pipe()
+		c := &Client{conn: conn}
+		go func(length int32, body string) {
+			binary.Write(server, binary.LittleEndian, length)
+			server.Write([]byte(body))
+		}(test.length, test.body)
+
+		data, err := c.readRawFrame()
+		switch {
+		case test.err == "" && (err != nil || string(data) != test.body):
+			t.Errorf("length %d: readRawFrame = %q, %v, want %q", test.length, data, err, test.body)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("length %d: readRawFrame error %v, want %q", test.length, err, test.err)
+		}
+		server.Close()
+		conn.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package pokeclient
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Coverage of a team, computed from the damage relations of the offered types
+type CoverageReport struct {
+	// Types hit super effectively by at least one type of the team
+	Hits []string
+	// Attacking types that are super effective against the team, with the
+	// number of members they hit
+	Weaknesses map[string]int
+	// Types of the team without damage relations in the offer
+	Unknown []string
+}
+
+// SharedWeaknesses lists the attacking types that hit two members or more
+func (r CoverageReport) SharedWeaknesses() []string {
+	var shared []string
+	for attacker, count := range r.Weaknesses {
+		if count >= 2 {
+			shared = append(shared, fmt.Sprintf("%s ×%d", attacker, count))
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+// TeamCoverage rates a team against the damage relations of the offered
+// types
+func TeamCoverage(offer []Pokemon, team []PokemonDetail) CoverageReport {
+	relations := make(map[string]DamageRelation)
+	for _, t := range offer {
+		relations[t.Name] = t.DAMAGE_RELATIONS
+	}
+
+	report := CoverageReport{Weaknesses: make(map[string]int)}
+	hits := make(map[string]bool)
+	unknown := make(map[string]bool)
+	for _, member := range team {
+		var known []DamageRelation
+		for _, t := range member.TYPES {
+			relation, ok := relations[t.TYPE.Name]
+			if !ok {
+				unknown[t.TYPE.Name] = true
+				continue
+			}
+			known = append(known, relation)
+			for _, target := range relation.DOUBLE_DAMAGE_TO {
+				hits[target.NAME] = true
+			}
+		}
+
+		// Multiply the effect of every attacking type over the member's types
+		for attacker := range relations {
+			multiplier := 1.0
+			for _, relation := range known {
+				multiplier *= DamageFrom(relation, attacker)
+			}
+			if multiplier > 1 {
+				report.Weaknesses[attacker]++
+			}
+		}
+	}
+
+	for name := range hits {
+		report.Hits = append(report.Hits, name)
+	}
+	for name := range unknown {
+		report.Unknown = append(report.Unknown, name)
+	}
+	sort.Strings(report.Hits)
+	sort.Strings(report.Unknown)
+	return report
+}
+
+// DamageFrom is the multiplier of an attacking type against a defending type
+func DamageFrom(defender DamageRelation, attacker string) float64 {
+	for _, item := range defender.NO_DAMAGE_FROM {
+		if item.NAME == attacker {
+			return 0
+		}
+	}
+	for _, item := range defender.DOUBLE_DAMAGE_FROM {
+		if item.NAME == attacker {
+			return 2
+		}
+	}
+	for _, item := range defender.HALF_DAMAGE_FROM {
+		if item.NAME == attacker {
+			return 0.5
+		}
+	}
+	return 1
+}
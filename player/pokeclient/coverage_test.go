@@ -0,0 +1,104 @@
+package pokeclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func items(names ...string) []DamageRelationItem {
+	var list []DamageRelationItem
+	for _, name := range names {
+		list = append(list, DamageRelationItem{NAME: name})
+	}
+	return list
+}
+
+func member(name string, types ...string) PokemonDetail {
+	detail := PokemonDetail{NAME: name}
+	for _, t := range types {
+		var pokemonType PokemonType
+		pokemonType.TYPE.Name = t
+		detail.TYPES = append(detail.TYPES, pokemonType)
+	}
+	return detail
+}
+
+// Damage relations of three types as PokéAPI has them
+var testOffer = []Pokemon{
+	{Name: "fire", DAMAGE_RELATIONS: DamageRelation{
+		DOUBLE_DAMAGE_FROM: items("water", "ground", "rock"),
+		DOUBLE_DAMAGE_TO:   items("grass", "ice", "bug", "steel"),
+		HALF_DAMAGE_FROM:   items("fire", "grass", "ice", "bug", "steel", "fairy"),
+	}},
+	{Name: "water", DAMAGE_RELATIONS: DamageRelation{
+		DOUBLE_DAMAGE_FROM: items("grass", "electric"),
+		DOUBLE_DAMAGE_TO:   items("fire", "ground", "rock"),
+		HALF_DAMAGE_FROM:   items("fire", "water", "ice", "steel"),
+	}},
+	{Name: "grass", DAMAGE_RELATIONS: DamageRelation{
+		DOUBLE_DAMAGE_FROM: items("fire", "ice", "poison", "flying", "bug"),
+		DOUBLE_DAMAGE_TO:   items("water", "ground", "rock"),
+		HALF_DAMAGE_FROM:   items("water", "electric", "grass", "ground"),
+	}},
+	{Name: "ground", DAMAGE_RELATIONS: DamageRelation{
+		NO_DAMAGE_FROM: items("electric"),
+	}},
+}
+
+func TestDamageFrom(t *testing.T) {
+	fire := testOffer[0].DAMAGE_RELATIONS
+	ground := testOffer[3].DAMAGE_RELATIONS
+	tests := []struct {
+		defender DamageRelation
+		attacker string
+		want     float64
+	}{
+		{fire, "water", 2},
+		{fire, "grass", 0.5},
+		{fire, "normal", 1},
+		{ground, "electric", 0},
+	}
+	for _, test := range tests {
+		if got := DamageFrom(test.defender, test.attacker); got != test.want {
+			t.Errorf("DamageFrom(%s) = %v, want %v", test.attacker, got, test.want)
+		}
+	}
+}
+
+func TestTeamCoverage(t *testing.T) {
+	team := []PokemonDetail{
+		member("charmander", "fire"),
+		member("squirtle", "water"),
+		member("bulbasaur", "grass", "poison"),
+	}
+	report := TeamCoverage(testOffer, team)
+
+	wantHits := []string{"bug", "fire", "grass", "ground", "ice", "rock", "steel", "water"}
+	if !reflect.DeepEqual(report.Hits, wantHits) {
+		t.Errorf("Hits = %v, want %v", report.Hits, wantHits)
+	}
+	// Poison has no relations in the offer, so bulbasaur counts as pure grass
+	if !reflect.DeepEqual(report.Unknown, []string{"poison"}) {
+		t.Errorf("Unknown = %v, want [poison]", report.Unknown)
+	}
+	wantWeaknesses := map[string]int{"fire": 1, "grass": 1, "ground": 1, "water": 1}
+	if !reflect.DeepEqual(report.Weaknesses, wantWeaknesses) {
+		t.Errorf("Weaknesses = %v, want %v", report.Weaknesses, wantWeaknesses)
+	}
+	if shared := report.SharedWeaknesses(); len(shared) != 0 {
+		t.Errorf("SharedWeaknesses = %v, want none", shared)
+	}
+}
+
+func TestTeamCoverageSharedWeakness(t *testing.T) {
+	// Grass hits both water members, water and ground only the fire one
+	team := []PokemonDetail{
+		member("squirtle", "water"),
+		member("psyduck", "water"),
+		member("ponyta", "fire"),
+	}
+	report := TeamCoverage(testOffer, team)
+	if shared := report.SharedWeaknesses(); !reflect.DeepEqual(shared, []string{"grass ×2"}) {
+		t.Errorf("SharedWeaknesses = %v, want [grass ×2]", shared)
+	}
+}
@@ -0,0 +1,270 @@
+package pokeclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The end to end test builds server/server.go and server/game.go and runs
+// them on free ports: server.go with two types written by writeTypes,
+// game.go with the pokedex, spawns and maps of the repository. It plays
+// through both the same way the player programs do.
+
+// build compiles one program of the server directory into dir
+func build(t *testing.T, dir, source string) string {
+	t.Helper()
+	binary := filepath.Join(dir, strings.TrimSuffix(source, ".go"))
+	cmd := exec.Command("go", "build", "-o", binary, source)
+	cmd.Dir = "../../server"
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build %s: %v\n%s", source, err, out)
+	}
+	return binary
+}
+
+// freeAddr is a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// start runs a program in dir until the test ends and waits for addr to
+// accept connections. Its log is printed when the test fails.
+func start(t *testing.T, dir, addr, binary string, args ...string) {
+	t.Helper()
+	var logs bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &logs
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+		if t.Failed() {
+			t.Logf("%s:\n%s", filepath.Base(binary), logs.String())
+		}
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			exited <- err
+			t.Fatalf("%s exited: %v", filepath.Base(binary), err)
+		default:
+		}
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s does not listen on %s", filepath.Base(binary), addr)
+}
+
+// writeTypes writes PokéAPI type lists for server.go: four fire and four
+// water Pokémon with the same base stats
+func writeTypes(t *testing.T, path string) {
+	t.Helper()
+	species := map[string][]string{
+		"fire":  {"4 charmander", "37 vulpix", "58 growlithe", "77 ponyta"},
+		"water": {"7 squirtle", "54 psyduck", "60 poliwag", "72 tentacool"},
+	}
+	relations := map[string]DamageRelation{
+		"fire":  {DOUBLE_DAMAGE_FROM: []DamageRelationItem{{NAME: "water"}}, HALF_DAMAGE_TO: []DamageRelationItem{{NAME: "water"}}},
+		"water": {DOUBLE_DAMAGE_TO: []DamageRelationItem{{NAME: "fire"}}, HALF_DAMAGE_FROM: []DamageRelationItem{{NAME: "fire"}}},
+	}
+
+	var types []Pokemon
+	for i, name := range []string{"fire", "water"} {
+		list := Pokemon{ID: 10 + i, Name: name, DAMAGE_RELATIONS: relations[name]}
+		for _, entry := range species[name] {
+			id, pokemonName, _ := strings.Cut(entry, " ")
+			detail := PokemonDetail{NAME: pokemonName, URL: "https://pokeapi.co/api/v2/pokemon/" + id + "/"}
+			for _, stat := range []string{"hp", "attack", "defense", "special-attack", "special-defense", "speed"} {
+				s := PokemonStats{BaseStat: 50}
+				s.Stat.Name = stat
+				detail.STATS = append(detail.STATS, s)
+			}
+			var pokemonType PokemonType
+			pokemonType.TYPE.Name = name
+			detail.TYPES = []PokemonType{pokemonType}
+			list.POKEMON = append(list.POKEMON, PokemonItem{POKEMON_DETAIL: detail})
+		}
+		types = append(types, list)
+	}
+	content, err := json.Marshal(types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect reads messages of the game until one contains want
+func expect(t *testing.T, g *Game, want string) string {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	var seen []string
+	for {
+		select {
+		case msg, ok := <-g.Messages():
+			if !ok {
+				t.Fatalf("connection closed (%v) waiting for %q after %q", g.Err(), want, seen)
+			}
+			if strings.Contains(msg, want) {
+				return msg
+			}
+			seen = append(seen, msg)
+		case <-timeout:
+			t.Fatalf("no %q after %q", want, seen)
+		}
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the servers")
+	}
+	bin := t.TempDir()
+	server := build(t, bin, "server.go")
+	game := build(t, bin, "game.go")
+
+	repo, err := filepath.Abs("../../server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	types := filepath.Join(dir, "types.json")
+	writeTypes(t, types)
+	pokedex := filepath.Join(repo, "pokedex.json")
+	accounts := filepath.Join(dir, "accounts.json")
+	teams := filepath.Join(dir, "players")
+
+	serverAddr := freeAddr(t)
+	start(t, dir, serverAddr, server,
+		"-tcp-addr", serverAddr, "-http-addr", freeAddr(t),
+		"-data", types, "-accounts", accounts, "-players", teams)
+
+	// Register on server.go and save a fire team
+	client, err := Connect(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	auth, err := client.Register("ash", "secret1")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if auth.AccountID == "" || auth.Rules == nil || auth.Rules.TeamSize != 3 {
+		t.Fatalf("Register = %+v, want an account and a team of 3", auth)
+	}
+	offer, err := client.Offer()
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+	fire := PokemonOfType(offer, "fire")
+	if len(fire) < 3 {
+		t.Fatalf("%d fire Pokémon on offer, want at least 3", len(fire))
+	}
+	if _, err := client.ChooseTeam("fire", []int{1, 1, 2}); err == nil {
+		t.Errorf("ChooseTeam accepted the same pick twice")
+	}
+	team, err := client.ChooseTeam("fire", []int{1, 2, 3})
+	if err != nil {
+		t.Fatalf("ChooseTeam: %v", err)
+	}
+	if online, err := client.Presence(); err != nil || len(online) != 1 || online[0].Name != "ash" {
+		t.Errorf("Presence = %v, %v, want ash", online, err)
+	}
+
+	other, err := Connect(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	var refused *ServerError
+	if _, err := other.Register("Ash", "secret2"); !errors.As(err, &refused) {
+		t.Errorf("Register of a taken name: %v, want a ServerError", err)
+	}
+
+	gameAddr := freeAddr(t)
+	start(t, dir, gameAddr, game,
+		"-addr", gameAddr, "-data", pokedex, "-seed", "7",
+		"-accounts", accounts, "-teams", teams, "-players", filepath.Join(dir, "collections.json"),
+		"-spawns", filepath.Join(repo, "spawns.yaml"), "-maps", filepath.Join(repo, "maps"),
+		"-encounter-rate", "0")
+
+	// game.go logs in with the server.go account
+	wrong, err := JoinLobby(gameAddr, "ash", "secret2", MODE_POKEBAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, wrong, "Wrong name or password")
+	wrong.Close()
+
+	// and battles with the saved team
+	pokebat, err := JoinLobby(gameAddr, "ash", "secret1", MODE_POKEBAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pokebat.Close()
+	expect(t, pokebat, "Welcome to POKEBAT, ash")
+	if err := pokebat.SendAction("ai greedy"); err != nil {
+		t.Fatal(err)
+	}
+	status := expect(t, pokebat, "wants to battle!")
+	for _, member := range team.Selected {
+		if !strings.Contains(strings.ToLower(status), member.NAME) {
+			t.Errorf("%s of the saved team is missing from\n%s", member.NAME, status)
+		}
+	}
+	if err := pokebat.SendAction("attack 9"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, pokebat, `You can't attack 9 now`)
+	if err := pokebat.SendAction("attack 1"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, pokebat, "Turn 2")
+	if err := pokebat.SendAction("run"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, pokebat, "ash ran away")
+	if err := pokebat.SendAction("quit"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, pokebat, "Bye")
+
+	// POKECAT starts on the first map and walks
+	pokecat, err := JoinLobby(gameAddr, "ash", "secret1", MODE_POKECAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pokecat.Close()
+	first := expect(t, pokecat, "Route 1")
+	if err := pokecat.SendAction("d"); err != nil {
+		t.Fatal(err)
+	}
+	if moved := expect(t, pokecat, "Route 1"); moved == first {
+		t.Errorf("walking right did not move the player:\n%s", moved)
+	}
+}
@@ -0,0 +1,93 @@
+package pokeclient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Game modes of the battle server
+const (
+	MODE_POKEBAT = 1
+	MODE_POKECAT = 2
+)
+
+// Game is a connection to the battle server. The server reads lines of text
+// and answers with messages terminated by '#'.
+type Game struct {
+	conn     net.Conn
+	messages chan string
+	err      error
+	writeMu  sync.Mutex
+}
+
+// JoinLobby connects to the battle server at addr and enters the given mode
+// as name, logging in with the password of the account on the server
+func JoinLobby(addr string, name string, password string, mode int) (*Game, error) {
+	if name == "" || strings.ContainsAny(name, " \r\n") {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	if password == "" || strings.ContainsAny(password, "\r\n") {
+		return nil, fmt.Errorf("invalid password")
+	}
+	if mode != MODE_POKEBAT && mode != MODE_POKECAT {
+		return nil, fmt.Errorf("unknown mode %d", mode)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &Game{conn: conn, messages: make(chan string, 64)}
+	if err := g.send(fmt.Sprintf("%s %d\n%s", name, mode, password)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go g.readLoop()
+	return g, nil
+}
+
+// SendAction sends one line of input: a command in POKEBAT, a key in POKECAT
+func (g *Game) SendAction(action string) error {
+	return g.send(strings.TrimRight(action, "\r\n"))
+}
+
+// Messages returns the messages of the server without their '#'. The
+// channel is closed when the connection is gone, Err tells why.
+func (g *Game) Messages() <-chan string {
+	return g.messages
+}
+
+func (g *Game) Err() error {
+	return g.err
+}
+
+func (g *Game) Close() error {
+	return g.conn.Close()
+}
+
+func (g *Game) send(line string) error {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	_, err := g.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (g *Game) readLoop() {
+	defer close(g.messages)
+	reader := bufio.NewReader(g.conn)
+	for {
+		msg, err := reader.ReadString('#')
+		if err != nil {
+			// The server closed the connection in the middle of a message
+			if msg != "" {
+				g.messages <- msg
+			}
+			g.err = err
+			return
+		}
+		g.messages <- msg[:len(msg)-1]
+	}
+}
@@ -0,0 +1,85 @@
+// Package pokeconfig loads the settings of every program in this module.
+//
+// Every setting is a flag and the config file and the environment both use
+// the flag names, so "idle-timeout: 5m" in the file and
+// POKEMON_IDLE_TIMEOUT=5m do the same as -idle-timeout 5m. Flags given on the
+// command line win over the environment, which wins over the file.
+package pokeconfig
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load applies the YAML file at path and the environment variables named
+// envPrefix + flag name to the flags of the command line. envPrefix +
+// "CONFIG" names another file when -config is not given. A missing file is
+// fine unless it was named explicitly. It returns the file it read, if any.
+func Load(path string, envPrefix string) (string, error) {
+	explicit := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	if _, ok := explicit["config"]; !ok {
+		if env, ok := os.LookupEnv(envPrefix + "CONFIG"); ok {
+			path = env
+			explicit["config"] = env
+		}
+	}
+
+	loaded := ""
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var settings map[string]any
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for name, value := range settings {
+			if name == "config" || flag.Lookup(name) == nil {
+				return "", fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			if err := flag.Set(name, Value(value)); err != nil {
+				return "", fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+		}
+		loaded = path
+	} else if _, ok := explicit["config"]; ok || !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var envErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(env)
+		if !ok || f.Name == "config" || envErr != nil {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("%s: %w", env, err)
+		}
+	})
+	if envErr != nil {
+		return loaded, envErr
+	}
+
+	for name, value := range explicit {
+		flag.Set(name, value)
+	}
+	return loaded, nil
+}
+
+// Value turns a YAML value into flag syntax, lists become comma separated
+func Value(value any) string {
+	if list, ok := value.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
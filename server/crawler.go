@@ -1,100 +1,136 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/chromedp/chromedp"
+
+	"pokemonproject/server/pokedata"
 )
 
-type Pokemon struct {
-	Index       string   `json:"index"`
-	Name        string   `json:"name"`
-	Exp         int      `json:"exp"`
-	HP          int      `json:"hp"`
-	Attack      int      `json:"attack"`
-	Defense     int      `json:"defense"`
-	SpAttack    int      `json:"sp_attack"`
-	SpDefense   int      `json:"sp_defense"`
-	Speed       int      `json:"speed"`
-	TotalEVs    int      `json:"total_evs"`
-	Type        []string `json:"type"`
-	Description string   `json:"description"`
-	Height      string   `json:"height"`
-	Weight      string   `json:"weight"`
-	ImageURL    string   `json:"image_url"`
+// Every page and document the crawl downloaded, in order
+var sources pokedata.Sources
+
+// How long the crawler waits for pokedex.org to render the requested Pokémon
+const pokemonPageTimeout = 15 * time.Second
+
+// detailReadyJS reports whether the detail panel shows the Pokémon we asked
+// for. pokedex.org only swaps the hash route, so the previous Pokémon stays on
+// screen until the new one has rendered. The page pads the number ("#001"),
+// so both sides are compared as numbers.
+const detailReadyJS = `(name, index) => {
+	const id = document.querySelector('.detail-national-id');
+	if (!id || parseInt(id.textContent.replace(/[^0-9]/g, ''), 10) !== parseInt(index, 10)) {
+		return false;
+	}
+	if (name === '') {
+		return true;
+	}
+	const header = document.querySelector('.detail-panel-header');
+	return !!header && header.textContent.trim().toLowerCase() === name.toLowerCase();
+}`
+
+// pokedex.org itself stops after generation 5
+const pokedexOrgLastIndex = 649
+
+// Which Pokémon a crawl fetches
+type crawlScope struct {
+	Generations  pokedata.Generations
+	Types        []string
+	PerTypeLimit int // 0 means no limit
 }
 
 func fetchMainPageHTML(ctx context.Context) (string, error) {
 	var html string
 	err := chromedp.Run(ctx,
 		chromedp.Navigate("https://pokedex.org/"),
-		chromedp.Sleep(1*time.Microsecond),
+		chromedp.WaitReady("#monsters-list-wrapper li"),
 		chromedp.OuterHTML("html", &html),
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to load main page: %v", err)
 	}
+	sources.Record("https://pokedex.org/", []byte(html))
 	return html, nil
 }
 
-func fetchPokemonPageHTML(ctx context.Context, url string) (string, error) {
+func fetchPokemonPageHTML(ctx context.Context, url, name, index string) (string, error) {
 	var html string
 	err := chromedp.Run(ctx,
 		chromedp.Navigate(url),
-		chromedp.Sleep(1*time.Microsecond),
+		// Wait until the detail panel shows this Pokémon, not the previous one
+		chromedp.PollFunction(detailReadyJS, nil,
+			chromedp.WithPollingArgs(name, index),
+			chromedp.WithPollingInterval(100*time.Millisecond),
+			chromedp.WithPollingTimeout(pokemonPageTimeout),
+		),
 		chromedp.OuterHTML("html", &html),
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to load Pokémon page: %v", err)
 	}
+	sources.Record(url, []byte(html))
 	return html, nil
 }
 
-func parseMainPage(html string) ([]string, []string, error) {
+func parseMainPage(html string, generations pokedata.Generations) ([]string, []string, []string, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse main page HTML: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to parse main page HTML: %v", err)
 	}
 
 	var urls []string
 	var names []string
+	var ids []string
 	doc.Find("#monsters-list-wrapper li").Each(func(i int, s *goquery.Selection) {
 		fmt.Println(s.Contents().Text())
-		if i >= 649 {
-			return
-		}
 		name := strings.TrimSpace(s.Find("span").Text())
 		classAttr, exists := s.Find("button").Attr("class")
-		id := ""
+		id := strconv.Itoa(i + 1)
 		if exists {
 			id = strings.TrimPrefix(classAttr, "monster-sprite sprite-")
 		}
+		// Only keep the generations we were asked for
+		index, err := strconv.Atoi(id)
+		if err != nil || !generations.Contains(index) {
+			return
+		}
 		url := fmt.Sprintf("https://pokedex.org/#/pokemon/%s", id)
 		names = append(names, name)
 		urls = append(urls, url)
+		ids = append(ids, id)
 	})
-	return names, urls, nil
+	return names, urls, ids, nil
 }
 
-func parsePokemonPage(html, name, index string) (Pokemon, error) {
+func parsePokemonPage(html, name, index string) (pokedata.Pokemon, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
-		return Pokemon{}, fmt.Errorf("failed to parse Pokémon page HTML: %v", err)
+		return pokedata.Pokemon{}, fmt.Errorf("failed to parse Pokémon page HTML: %v", err)
 	}
 
-	pokemon := Pokemon{Index: index, Name: name, Type: []string{}}
+	// Make sure the page belongs to the requested Pokémon before using it
+	pageIndex := strings.TrimLeft(strings.TrimSpace(doc.Find(".detail-national-id").Text()), "#0")
+	if pageIndex != index {
+		return pokedata.Pokemon{}, fmt.Errorf("page shows Pokémon #%s, expected #%s", pageIndex, index)
+	}
+
+	number, _ := strconv.Atoi(index)
+	pokemon := pokedata.Pokemon{Index: index, Name: name, Generation: pokedata.GenerationOf(number), Type: []string{}}
 
 	doc.Find(".detail-types .monster-type").Each(func(i int, s *goquery.Selection) {
-		pokemon.Type = append(pokemon.Type, strings.TrimSpace(s.Text()))
+		pokemon.Type = append(pokemon.Type, strings.ToLower(strings.TrimSpace(s.Text())))
 	})
 
 	doc.Find(".detail-stats-row").Each(func(i int, s *goquery.Selection) {
@@ -126,69 +162,287 @@ func parsePokemonPage(html, name, index string) (Pokemon, error) {
 	return pokemon, nil
 }
 
-func fetchExpAndImage(index string) (int, string, string, error) {
-	url := "https://bulbapedia.bulbagarden.net/wiki/List_of_Pok%C3%A9mon_by_effort_value_yield_(Generation_IX)"
-	resp, err := http.Get(url)
+const bulbapediaEVURL = "https://bulbapedia.bulbagarden.net/wiki/List_of_Pok%C3%A9mon_by_effort_value_yield_(Generation_IX)"
+
+// One row of Bulbapedia's effort value yield table. Pokémon with several
+// forms have one row per form.
+type bulbapediaRow struct {
+	Name     string
+	Form     string
+	Exp      int
+	ImageURL string
+}
+
+// fetchBulbapediaRows loads the effort value yield table once and groups its
+// rows by national dex number
+func fetchBulbapediaRows() (map[int][]bulbapediaRow, error) {
+	resp, err := http.Get(bulbapediaEVURL)
 	if err != nil {
-		return 0, "", "", fmt.Errorf("failed to fetch Bulbapedia page: %v", err)
+		return nil, fmt.Errorf("failed to fetch Bulbapedia page: %v", err)
 	}
 	defer resp.Body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, "", "", fmt.Errorf("failed to parse Bulbapedia page HTML: %v", err)
+		return nil, fmt.Errorf("failed to read Bulbapedia page: %v", err)
 	}
+	sources.Record(bulbapediaEVURL, body)
 
-	pokemonIndex := fmt.Sprintf("%04s", index)
-	var expText, imageURL, name string
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Bulbapedia page HTML: %v", err)
+	}
+
+	rows := make(map[int][]bulbapediaRow)
 	doc.Find("tr").Each(func(i int, s *goquery.Selection) {
-		if strings.TrimSpace(s.Find("td").First().Text()) == pokemonIndex {
-			expText = s.Find("td").Eq(3).Text()
-			imageURL = s.Find("td").Eq(1).Find("img").AttrOr("src", "")
-			name = s.Find("td").Eq(2).Text()
+		cells := s.Find("td")
+		if cells.Length() < 4 {
+			return
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(cells.First().Text()))
+		if err != nil {
 			return
 		}
+		exp, err := strconv.Atoi(strings.TrimSpace(cells.Eq(3).Text()))
+		if err != nil {
+			fmt.Printf("Skipping Bulbapedia row for #%d: failed to parse EXP value: %v\n", index, err)
+			return
+		}
+		nameCell := cells.Eq(2)
+		rows[index] = append(rows[index], bulbapediaRow{
+			Name:     strings.TrimSpace(nameCell.Find("a").First().Text()),
+			Form:     strings.TrimSpace(nameCell.Find("small").Text()),
+			Exp:      exp,
+			ImageURL: cells.Eq(1).Find("img").AttrOr("src", ""),
+		})
 	})
 
-	expText = strings.TrimSpace(expText)
-	exp, err := strconv.Atoi(expText)
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows found on Bulbapedia page")
+	}
+	return rows, nil
+}
+
+// findBulbapediaRow picks the row of a form. An empty form picks the default
+// row, which Bulbapedia lists without a form label.
+func findBulbapediaRow(rows []bulbapediaRow, form string) (bulbapediaRow, bool) {
+	if form == "" {
+		for _, row := range rows {
+			if row.Form == "" {
+				return row, true
+			}
+		}
+		if len(rows) > 0 {
+			return rows[0], true
+		}
+		return bulbapediaRow{}, false
+	}
+
+	// "alola" matches "Alolan Form", "mega-x" matches "Mega Charizard X"
+	for _, row := range rows {
+		words := strings.Fields(strings.ToLower(row.Name + " " + row.Form))
+		matched := true
+		for _, part := range strings.Split(form, "-") {
+			found := false
+			for _, word := range words {
+				if strings.HasPrefix(word, part) {
+					found = true
+					break
+				}
+			}
+			matched = matched && found
+		}
+		if matched && row.Form != "" {
+			return row, true
+		}
+	}
+	return bulbapediaRow{}, false
+}
+
+type pokeAPISpecies struct {
+	Varieties []struct {
+		IsDefault bool `json:"is_default"`
+		Pokemon   struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"pokemon"`
+	} `json:"varieties"`
+}
+
+type pokeAPIPokemon struct {
+	Name   string `json:"name"`
+	Height int    `json:"height"`
+	Weight int    `json:"weight"`
+	Stats  []struct {
+		BaseStat int `json:"base_stat"`
+		Stat     struct {
+			Name string `json:"name"`
+		} `json:"stat"`
+	} `json:"stats"`
+	Types []struct {
+		Type struct {
+			Name string `json:"name"`
+		} `json:"type"`
+	} `json:"types"`
+}
+
+func getJSON(url string, v any) error {
+	resp, err := http.Get(url)
 	if err != nil {
-		return 0, "", "", fmt.Errorf("failed to parse EXP value: %v", err)
+		return fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", url, err)
+	}
+	sources.Record(url, body)
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", url, err)
+	}
+	return nil
+}
+
+// fetchForms returns the alternate forms of a species (regional variants,
+// megas and so on) with their own stats, types and sprite. pokedex.org only
+// knows default forms, so the forms come from PokéAPI.
+func fetchForms(base pokedata.Pokemon, rows []bulbapediaRow) ([]pokedata.Pokemon, error) {
+	var species pokeAPISpecies
+	if err := getJSON("https://pokeapi.co/api/v2/pokemon-species/"+base.Index, &species); err != nil {
+		return nil, err
+	}
+
+	// PokéAPI names forms after the default variety, e.g. raichu-alola
+	speciesName := strings.ToLower(base.Species)
+	for _, variety := range species.Varieties {
+		if variety.IsDefault {
+			speciesName = variety.Pokemon.Name
+		}
+	}
+
+	var forms []pokedata.Pokemon
+	for _, variety := range species.Varieties {
+		if variety.IsDefault {
+			continue
+		}
+
+		var detail pokeAPIPokemon
+		if err := getJSON(variety.Pokemon.URL, &detail); err != nil {
+			return forms, err
+		}
+
+		form := strings.TrimPrefix(detail.Name, speciesName+"-")
+
+		pokemon := pokedata.Pokemon{
+			Index:       base.Index,
+			Name:        formName(base.Name, form),
+			Species:     base.Species,
+			Form:        form,
+			Generation:  base.Generation,
+			Description: base.Description,
+			Height:      fmt.Sprintf("%.1f m", float64(detail.Height)/10),
+			Weight:      fmt.Sprintf("%.1f kg", float64(detail.Weight)/10),
+			Type:        []string{},
+		}
+		for _, t := range detail.Types {
+			pokemon.Type = append(pokemon.Type, t.Type.Name)
+		}
+		for _, stat := range detail.Stats {
+			switch stat.Stat.Name {
+			case "hp":
+				pokemon.HP = stat.BaseStat
+			case "attack":
+				pokemon.Attack = stat.BaseStat
+			case "defense":
+				pokemon.Defense = stat.BaseStat
+			case "special-attack":
+				pokemon.SpAttack = stat.BaseStat
+			case "special-defense":
+				pokemon.SpDefense = stat.BaseStat
+			case "speed":
+				pokemon.Speed = stat.BaseStat
+			}
+		}
+		pokemon.TotalEVs = pokemon.HP + pokemon.Attack + pokemon.Defense + pokemon.SpAttack + pokemon.SpDefense + pokemon.Speed
+
+		if row, ok := findBulbapediaRow(rows, form); ok {
+			pokemon.Exp = row.Exp
+			pokemon.ImageURL = row.ImageURL
+		} else {
+			fmt.Printf("No Bulbapedia row for %s (%s)\n", base.Name, form)
+			pokemon.Exp = base.Exp
+		}
+
+		forms = append(forms, pokemon)
 	}
 
-	return exp, imageURL, name, nil
+	return forms, nil
 }
 
-func fetchPokemons(ctx context.Context) ([]Pokemon, error) {
+// formName names a form after its species, e.g. "Raichu (Alola)" or
+// "Charizard (Mega X)"
+func formName(species, form string) string {
+	words := strings.Split(form, "-")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return fmt.Sprintf("%s (%s)", species, strings.Join(words, " "))
+}
+
+func fetchPokemons(ctx context.Context, scope crawlScope, withForms bool) ([]pokedata.Pokemon, error) {
 	html, err := fetchMainPageHTML(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	names, urls, err := parseMainPage(html)
+	rows, err := fetchBulbapediaRows()
+	if err != nil {
+		return nil, err
+	}
+
+	names, urls, ids, err := parseMainPage(html, scope.Generations)
 	fmt.Printf("Found %d Pokémon\n", len(names))
 	fmt.Printf("Found %d Pokémon\n", len(urls))
 	if err != nil {
 		return nil, err
 	}
 
-	var pokemons []Pokemon
+	// Number of Pokémon kept so far for every requested type
+	typeCounts := make(map[string]int)
+
+	var pokemons []pokedata.Pokemon
 	for i, url := range urls {
+		if scope.isFull(typeCounts) {
+			break
+		}
 		name := names[i]
+		index := ids[i]
 		fmt.Printf("Fetching data for %s (%s)\n", name, url)
-		pokemonHTML, err := fetchPokemonPageHTML(ctx, url)
+		pokemonHTML, err := fetchPokemonPageHTML(ctx, url, name, index)
 		if err != nil {
 			fmt.Printf("Error fetching data for %s: %v\n", name, err)
 			continue
 		}
-		pokemon, err := parsePokemonPage(pokemonHTML, name, strconv.Itoa(i+1))
+		pokemon, err := parsePokemonPage(pokemonHTML, name, index)
 		if err != nil {
 			fmt.Printf("Error parsing data for %s: %v\n", name, err)
 			continue
 		}
-		exp, imageURL, newName, err := fetchExpAndImage(pokemon.Index)
-		if err != nil {
-			fmt.Printf("Error fetching EXP and image for %s: %v\n", pokemon.Name, err)
+		if !scope.accept(pokemon, typeCounts) {
+			fmt.Printf("Skipping %s: outside the requested types\n", name)
+			continue
+		}
+		number, _ := strconv.Atoi(pokemon.Index)
+		row, ok := findBulbapediaRow(rows[number], "")
+		if !ok {
+			fmt.Printf("Error fetching EXP and image for %s: no Bulbapedia row for #%s\n", pokemon.Name, pokemon.Index)
 		}
 		// if pokemon.Name == "" && imageURL != "https://archives.bulbagarden.net/media/upload/thumb/0/02/0250Ho-Oh.png/250px-0250Ho-Oh.png" {
 		// 	var partOfImageURL = strings.Split(strings.Split(imageURL, "-")[1], ".")
@@ -197,18 +451,84 @@ func fetchPokemons(ctx context.Context) ([]Pokemon, error) {
 		// 	pokemon.Name = partOfImageURL[0][4:]
 		// }
 		if pokemon.Name == "" {
-			pokemon.Name = newName[:len(newName)-1]
+			pokemon.Name = row.Name
 		}
-		pokemon.Exp = exp
-		pokemon.ImageURL = imageURL
+		pokemon.Species = pokemon.Name
+		pokemon.Exp = row.Exp
+		pokemon.ImageURL = row.ImageURL
 		pokemons = append(pokemons, pokemon)
 		fmt.Printf("Fetched: %+v\n", pokemon)
+
+		if !withForms {
+			continue
+		}
+		forms, err := fetchForms(pokemon, rows[number])
+		if err != nil {
+			fmt.Printf("Error fetching forms of %s: %v\n", pokemon.Name, err)
+		}
+		for _, form := range forms {
+			// Forms can have other types than their species
+			if !scope.accept(form, typeCounts) {
+				fmt.Printf("Skipping %s: outside the requested types\n", form.Name)
+				continue
+			}
+			pokemons = append(pokemons, form)
+			fmt.Printf("Fetched form: %+v\n", form)
+		}
 	}
 
 	return pokemons, nil
 }
 
+// accept reports whether a Pokémon falls into the scope and counts it
+// against the limit of every requested type it has.
+func (scope crawlScope) accept(pokemon pokedata.Pokemon, typeCounts map[string]int) bool {
+	var matched []string
+	for _, t := range pokemon.Type {
+		for _, wanted := range scope.Types {
+			if t == wanted && (scope.PerTypeLimit == 0 || typeCounts[t] < scope.PerTypeLimit) {
+				matched = append(matched, t)
+			}
+		}
+	}
+	for _, t := range matched {
+		typeCounts[t]++
+	}
+	return len(matched) > 0
+}
+
+// isFull reports whether every requested type already reached its limit
+func (scope crawlScope) isFull(typeCounts map[string]int) bool {
+	if scope.PerTypeLimit == 0 {
+		return false
+	}
+	for _, t := range scope.Types {
+		if typeCounts[t] < scope.PerTypeLimit {
+			return false
+		}
+	}
+	return true
+}
+
 func main() {
+	generations := flag.String("gen", pokedata.DefaultGenerations, "generation range to crawl, e.g. 1 or 1-5")
+	types := flag.String("types", pokedata.DefaultTypes, "comma separated list of types to crawl")
+	limit := flag.Int("limit", 0, "maximum number of Pokémon per type, 0 for no limit")
+	withForms := flag.Bool("forms", true, "also fetch regional variants, megas and other forms")
+	flag.Parse()
+
+	scope := crawlScope{PerTypeLimit: *limit}
+	var err error
+	if scope.Generations, err = pokedata.ParseGenerations(*generations); err != nil {
+		log.Fatal(err)
+	}
+	if scope.Types, err = pokedata.ParseTypes(*types); err != nil {
+		log.Fatal(err)
+	}
+	if scope.Generations.LastIndex() > pokedexOrgLastIndex {
+		fmt.Printf("pokedex.org only lists Pokémon up to #%d, later generations will be empty\n", pokedexOrgLastIndex)
+	}
+
 	opts := []chromedp.ExecAllocatorOption{
 		chromedp.Headless,
 		chromedp.DisableGPU,
@@ -220,22 +540,26 @@ func main() {
 	ctx, cancel = chromedp.NewContext(ctx)
 	defer cancel()
 
-	pokemons, err := fetchPokemons(ctx)
+	pokemons, err := fetchPokemons(ctx, scope, *withForms)
 	if err != nil {
 		log.Fatalf("Error fetching Pokémon data: %v", err)
 	}
 
-	file, err := os.Create("pokedex.json")
-	if err != nil {
-		log.Fatalf("Error creating file: %v", err)
-	}
-	defer file.Close()
-
 	jsonData, err := json.MarshalIndent(pokemons, "", "  ")
 	if err != nil {
 		log.Fatalf("Error marshalling JSON: %v", err)
 	}
-	file.Write(jsonData)
+	file, err := pokedata.WriteFile("pokedex.json", jsonData, len(pokemons))
+	if err != nil {
+		log.Fatalf("Error writing pokedex.json: %v", err)
+	}
 
 	fmt.Println("Pokedex data has been written to pokedex.json")
+
+	manifestPath := pokedata.ManifestPath("pokedex.json")
+	manifest := pokedata.NewManifest(pokedata.Producer("crawler.go"), pokedata.SchemaCrawler, []pokedata.File{file}, sources)
+	if err := manifest.Write(manifestPath); err != nil {
+		log.Fatalf("Error writing %s: %v", manifestPath, err)
+	}
+	fmt.Printf("Dataset version %s has been written to %s\n", manifest.Version, manifestPath)
 }
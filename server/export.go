@@ -0,0 +1,598 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"pokemonproject/server/pokedata"
+)
+
+// export.go writes the Pokédex in other formats for analysis:
+//
+//	go run export.go -format csv -fields index,name,type,speed -filter "type=fire,speed>=80"
+//	go run export.go -format sqlite -out pokedex.db
+//	go run export.go -format md -filter "generation=1"
+//
+// Species come from pokedex.json (written by crawler.go). Evolutions, moves
+// and learnsets come from the files written by main.go when they exist.
+
+type move struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Power    int    `json:"power"`
+	Accuracy int    `json:"accuracy"`
+	PP       int    `json:"pp"`
+	Category string `json:"category"`
+}
+
+type learnset struct {
+	ID      int `json:"national_id"`
+	LevelUp []struct {
+		Level int    `json:"level"`
+		Move  string `json:"move"`
+	} `json:"level_up"`
+}
+
+// Everything the export works on
+type exportData struct {
+	Pokemons   []pokedata.Pokemon
+	Evolutions []pokedata.Evolution
+	Moves      []move
+	Learnsets  []learnset
+	Names      map[int]string
+}
+
+// A column of the tabular formats
+type column struct {
+	Name  string
+	Value func(p pokedata.Pokemon, data exportData) string
+}
+
+var columns = []column{
+	{"index", func(p pokedata.Pokemon, d exportData) string { return p.Index }},
+	{"name", func(p pokedata.Pokemon, d exportData) string { return p.Name }},
+	{"species", func(p pokedata.Pokemon, d exportData) string { return p.Species }},
+	{"form", func(p pokedata.Pokemon, d exportData) string { return p.Form }},
+	{"generation", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.Generation) }},
+	{"type", func(p pokedata.Pokemon, d exportData) string { return strings.Join(p.Type, "/") }},
+	{"exp", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.Exp) }},
+	{"hp", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.HP) }},
+	{"attack", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.Attack) }},
+	{"defense", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.Defense) }},
+	{"sp_attack", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.SpAttack) }},
+	{"sp_defense", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.SpDefense) }},
+	{"speed", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.Speed) }},
+	{"total_evs", func(p pokedata.Pokemon, d exportData) string { return strconv.Itoa(p.TotalEVs) }},
+	{"height", func(p pokedata.Pokemon, d exportData) string { return p.Height }},
+	{"weight", func(p pokedata.Pokemon, d exportData) string { return p.Weight }},
+	{"description", func(p pokedata.Pokemon, d exportData) string { return p.Description }},
+	{"image_url", func(p pokedata.Pokemon, d exportData) string { return p.ImageURL }},
+	{"evolves_from", func(p pokedata.Pokemon, d exportData) string { return evolutionText(p, d, false) }},
+	{"evolves_to", func(p pokedata.Pokemon, d exportData) string { return evolutionText(p, d, true) }},
+}
+
+var defaultFields = "index,name,form,type,hp,attack,defense,sp_attack,sp_defense,speed,total_evs,evolves_to"
+
+func main() {
+	format := flag.String("format", "csv", "output format: csv, sqlite, md or html")
+	out := flag.String("out", "", "output file, stdout when empty (required for sqlite)")
+	fields := flag.String("fields", defaultFields, "comma separated columns for csv, md and html, \"all\" for every column")
+	filter := flag.String("filter", "", "comma separated conditions, e.g. \"type=fire,speed>=80\"")
+	pokedexFile := flag.String("pokedex", "pokedex.json", "species written by crawler.go")
+	evolutionFile := flag.String("evolutions", "pokemon.json", "evolutions written by main.go")
+	movesFile := flag.String("moves", "moves.json", "moves written by main.go")
+	learnsetFile := flag.String("learnsets", "learnsets.json", "learnsets written by main.go")
+	flag.Parse()
+
+	// The database always has every column, its schema is fixed
+	if *format == "sqlite" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "fields" {
+				log.Fatal("-fields does not apply to sqlite exports")
+			}
+		})
+	}
+
+	data, err := loadExportData(*pokedexFile, *evolutionFile, *movesFile, *learnsetFile)
+	if err != nil {
+		log.Fatalf("Failed to load data: %v", err)
+	}
+
+	conditions, err := parseFilter(*filter)
+	if err != nil {
+		log.Fatalf("Invalid filter: %v", err)
+	}
+	var selected []pokedata.Pokemon
+	for _, p := range data.Pokemons {
+		if matchesFilter(p, data, conditions) {
+			selected = append(selected, p)
+		}
+	}
+	data.Pokemons = selected
+
+	if *format == "sqlite" {
+		if *out == "" {
+			log.Fatal("sqlite export needs -out")
+		}
+		if err := writeSQLite(*out, data); err != nil {
+			log.Fatalf("Failed to write %s: %v", *out, err)
+		}
+		fmt.Printf("Exported %d Pokémon to %s\n", len(data.Pokemons), *out)
+		return
+	}
+
+	cols, err := selectColumns(*fields)
+	if err != nil {
+		log.Fatalf("Invalid fields: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch *format {
+	case "csv":
+		err = writeCSV(w, cols, data)
+	case "md", "markdown":
+		err = writeMarkdown(w, cols, data)
+	case "html":
+		err = writeHTML(w, cols, data)
+	default:
+		log.Fatalf("Unknown format %q", *format)
+	}
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+}
+
+func loadExportData(pokedexFile, evolutionFile, movesFile, learnsetFile string) (exportData, error) {
+	data := exportData{Names: map[int]string{}}
+
+	if err := readJSONFile(pokedexFile, &data.Pokemons, true); err != nil {
+		return data, err
+	}
+	for _, p := range data.Pokemons {
+		id, _ := strconv.Atoi(p.Index)
+		if p.Form == "" {
+			data.Names[id] = p.Name
+		}
+	}
+
+	var entries []pokedata.EvolutionEntry
+	if err := readJSONFile(evolutionFile, &entries, false); err != nil {
+		return data, err
+	}
+	seen := map[[2]int]bool{}
+	for _, e := range pokedata.EvolutionEdges(entries) {
+		if e.From == 0 || e.To == 0 || seen[[2]int{e.From, e.To}] {
+			continue
+		}
+		seen[[2]int{e.From, e.To}] = true
+		data.Evolutions = append(data.Evolutions, e)
+	}
+
+	if err := readJSONFile(movesFile, &data.Moves, false); err != nil {
+		return data, err
+	}
+	if err := readJSONFile(learnsetFile, &data.Learnsets, false); err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+// readJSONFile decodes filename into v. Optional files may be missing.
+func readJSONFile(filename string, v any, required bool) error {
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", filename, err)
+	}
+	return nil
+}
+
+// evolutionText lists the evolutions into (to=false) or out of (to=true) a
+// species, e.g. "Ivysaur (level 16)"
+func evolutionText(p pokedata.Pokemon, data exportData, to bool) string {
+	id, _ := strconv.Atoi(p.Index)
+	var parts []string
+	for _, e := range data.Evolutions {
+		other := 0
+		if to && e.From == id {
+			other = e.To
+		} else if !to && e.To == id {
+			other = e.From
+		} else {
+			continue
+		}
+
+		name := data.Names[other]
+		if name == "" {
+			name = "#" + strconv.Itoa(other)
+		}
+		trigger := e.Trigger
+		if e.Level > 0 {
+			trigger += " " + strconv.Itoa(e.Level)
+		}
+		if e.Item != "" {
+			trigger += " " + e.Item
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", name, trigger))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func selectColumns(fields string) ([]column, error) {
+	if fields == "all" {
+		return columns, nil
+	}
+	var selected []column
+	for _, name := range strings.Split(fields, ",") {
+		name = strings.TrimSpace(name)
+		col, ok := findColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		selected = append(selected, col)
+	}
+	return selected, nil
+}
+
+func findColumn(name string) (column, bool) {
+	for _, col := range columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// One condition of -filter, e.g. speed>=80
+type condition struct {
+	Column   column
+	Operator string
+	Value    string
+}
+
+func parseFilter(filter string) ([]condition, error) {
+	var conditions []condition
+	if strings.TrimSpace(filter) == "" {
+		return conditions, nil
+	}
+
+	for _, part := range strings.Split(filter, ",") {
+		part = strings.TrimSpace(part)
+		found := false
+		// Two character operators first so ">=" is not read as ">"
+		for _, op := range []string{">=", "<=", "!=", "=", ">", "<"} {
+			name, value, ok := strings.Cut(part, op)
+			if !ok {
+				continue
+			}
+			col, known := findColumn(strings.TrimSpace(name))
+			if !known {
+				return nil, fmt.Errorf("unknown field %q", name)
+			}
+			conditions = append(conditions, condition{Column: col, Operator: op, Value: strings.TrimSpace(value)})
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("condition %q has no operator", part)
+		}
+	}
+	return conditions, nil
+}
+
+// matchesFilter compares numbers as numbers and everything else as text.
+// For the type column "=" matches any of the Pokémon's types.
+func matchesFilter(p pokedata.Pokemon, data exportData, conditions []condition) bool {
+	for _, c := range conditions {
+		value := c.Column.Value(p, data)
+
+		if c.Column.Name == "type" && (c.Operator == "=" || c.Operator == "!=") {
+			has := false
+			for _, t := range p.Type {
+				has = has || strings.EqualFold(t, c.Value)
+			}
+			if has != (c.Operator == "=") {
+				return false
+			}
+			continue
+		}
+
+		cmp := strings.Compare(strings.ToLower(value), strings.ToLower(c.Value))
+		a, errA := strconv.Atoi(value)
+		b, errB := strconv.Atoi(c.Value)
+		if errA == nil && errB == nil {
+			cmp = a - b
+		}
+
+		var ok bool
+		switch c.Operator {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func writeCSV(w io.Writer, cols []column, data exportData) error {
+	writer := csv.NewWriter(w)
+
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, p := range data.Pokemons {
+		record := make([]string, len(cols))
+		for i, col := range cols {
+			record[i] = col.Value(p, data)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeMarkdown(w io.Writer, cols []column, data exportData) error {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+
+	var b strings.Builder
+	b.WriteString("|")
+	for _, col := range cols {
+		b.WriteString(" " + col.Name + " |")
+	}
+	b.WriteString("\n|")
+	for range cols {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+
+	for _, p := range data.Pokemons {
+		b.WriteString("|")
+		for _, col := range cols {
+			b.WriteString(" " + escape.Replace(col.Value(p, data)) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHTML(w io.Writer, cols []column, data exportData) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Pokédex</title></head>\n<body>\n<table>\n<thead><tr>")
+	for _, col := range cols {
+		b.WriteString("<th>" + html.EscapeString(col.Name) + "</th>")
+	}
+	b.WriteString("</tr></thead>\n<tbody>\n")
+
+	for _, p := range data.Pokemons {
+		b.WriteString("<tr>")
+		for _, col := range cols {
+			b.WriteString("<td>" + html.EscapeString(col.Value(p, data)) + "</td>")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</tbody>\n</table>\n</body>\n</html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+const sqliteSchema = `
+CREATE TABLE species (
+	id          INTEGER PRIMARY KEY,
+	national_id INTEGER NOT NULL,
+	form        TEXT NOT NULL DEFAULT '',
+	name        TEXT NOT NULL,
+	generation  INTEGER,
+	exp         INTEGER,
+	hp          INTEGER,
+	attack      INTEGER,
+	defense     INTEGER,
+	sp_attack   INTEGER,
+	sp_defense  INTEGER,
+	speed       INTEGER,
+	total_evs   INTEGER,
+	height      TEXT,
+	weight      TEXT,
+	description TEXT,
+	image_url   TEXT,
+	UNIQUE (national_id, form)
+);
+CREATE TABLE types (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE species_types (
+	species_id INTEGER NOT NULL REFERENCES species(id),
+	type_id    INTEGER NOT NULL REFERENCES types(id),
+	slot       INTEGER NOT NULL,
+	PRIMARY KEY (species_id, slot)
+);
+CREATE TABLE evolutions (
+	from_national_id INTEGER NOT NULL,
+	to_national_id   INTEGER NOT NULL,
+	trigger          TEXT NOT NULL,
+	level            INTEGER,
+	item             TEXT,
+	PRIMARY KEY (from_national_id, to_national_id)
+);
+CREATE TABLE moves (
+	id       INTEGER PRIMARY KEY,
+	name     TEXT NOT NULL UNIQUE,
+	type_id  INTEGER REFERENCES types(id),
+	power    INTEGER,
+	accuracy INTEGER,
+	pp       INTEGER,
+	category TEXT
+);
+CREATE TABLE learnsets (
+	national_id INTEGER NOT NULL,
+	move_id     INTEGER NOT NULL REFERENCES moves(id),
+	level       INTEGER NOT NULL
+);
+`
+
+// writeSQLite writes a fresh normalized database with one row per species
+// form and lookup tables for types and moves
+func writeSQLite(filename string, data exportData) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	typeIDs := map[string]int64{}
+	typeID := func(name string) (int64, error) {
+		name = strings.ToLower(name)
+		if id, ok := typeIDs[name]; ok {
+			return id, nil
+		}
+		res, err := tx.Exec(`INSERT INTO types (name) VALUES (?)`, name)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		typeIDs[name] = id
+		return id, err
+	}
+
+	exported := map[int]bool{}
+	for _, p := range data.Pokemons {
+		nationalID, err := strconv.Atoi(p.Index)
+		if err != nil {
+			return fmt.Errorf("%s has invalid index %q", p.Name, p.Index)
+		}
+		exported[nationalID] = true
+
+		res, err := tx.Exec(`INSERT INTO species (national_id, form, name, generation, exp, hp, attack, defense,
+			sp_attack, sp_defense, speed, total_evs, height, weight, description, image_url)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nationalID, p.Form, p.Name, p.Generation, p.Exp, p.HP, p.Attack, p.Defense,
+			p.SpAttack, p.SpDefense, p.Speed, p.TotalEVs, p.Height, p.Weight, p.Description, p.ImageURL)
+		if err != nil {
+			return fmt.Errorf("failed to insert %s: %w", p.Name, err)
+		}
+		speciesID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for slot, t := range p.Type {
+			id, err := typeID(t)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO species_types (species_id, type_id, slot) VALUES (?, ?, ?)`, speciesID, id, slot+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range data.Evolutions {
+		if !exported[e.From] && !exported[e.To] {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO evolutions (from_national_id, to_national_id, trigger, level, item) VALUES (?, ?, ?, ?, ?)`,
+			e.From, e.To, e.Trigger, e.Level, e.Item); err != nil {
+			return fmt.Errorf("failed to insert evolution %d -> %d: %w", e.From, e.To, err)
+		}
+	}
+
+	moveIDs := map[string]int64{}
+	for _, m := range data.Moves {
+		var moveType any
+		if m.Type != "" {
+			id, err := typeID(m.Type)
+			if err != nil {
+				return err
+			}
+			moveType = id
+		}
+		res, err := tx.Exec(`INSERT INTO moves (name, type_id, power, accuracy, pp, category) VALUES (?, ?, ?, ?, ?, ?)`,
+			m.Name, moveType, m.Power, m.Accuracy, m.PP, m.Category)
+		if err != nil {
+			return fmt.Errorf("failed to insert move %s: %w", m.Name, err)
+		}
+		if moveIDs[m.Name], err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range data.Learnsets {
+		if !exported[l.ID] {
+			continue
+		}
+		for _, entry := range l.LevelUp {
+			moveID, ok := moveIDs[entry.Move]
+			if !ok {
+				return fmt.Errorf("learnset of #%d uses unknown move %q", l.ID, entry.Move)
+			}
+			if _, err := tx.Exec(`INSERT INTO learnsets (national_id, move_id, level) VALUES (?, ?, ?)`, l.ID, moveID, entry.Level); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,1196 @@
+package main
+
+// game.go is the POKEBAT/POKECAT server player/player.go connects to. A
+// player sends "[name] [mode]" on the first line, the password of their
+// server.go account on the second and one action per line after that, every
+// message back ends with '#'.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"pokemonproject/pokeconfig"
+	"pokemonproject/server/pokedata"
+	"pokemonproject/server/pokegame"
+)
+
+// Address of the game server
+var GAME_ADDR = ":3015"
+
+// Pokedex written by crawler.go and the collections of the players
+var DATA_FILE = "pokedex.json"
+var PLAYERS_FILE = "players.json"
+
+// Accounts of server.go, players log in with the same name and password
+var ACCOUNTS_FILE = "accounts.json"
+
+// Teams saved by server.go, one file per account in its -players directory
+var TEAMS_DIR = "players"
+
+// Moves and level-up learnsets written by main.go. Without them Pokémon
+// attack with their own types.
+var MOVES_FILE = "moves.json"
+var LEARNSETS_FILE = "learnsets.json"
+
+// Number of Pokémon each side brings to a battle
+var TEAM_SIZE = 3
+
+// Computer trainer of a lobby created without a level
+var DEFAULT_AI = "greedy"
+
+// Seed of the battles' random numbers, 0 for a new seed every time
+var RANDOM_SEED int64 = 0
+
+// Chance of meeting a wild Pokémon on every step in a spawn region, unless
+// the region sets its own
+var ENCOUNTER_RATE = 0.15
+
+// Wild Pokémon of every region, see spawns.yaml
+var SPAWNS_FILE = "spawns.yaml"
+
+// Hour of the day for spawns, -1 for the clock
+var SPAWN_HOUR = -1
+
+// Drop players that send nothing for this long, 0 to disable
+var READ_TIMEOUT = 10 * time.Minute
+var WRITE_TIMEOUT = 10 * time.Second
+
+// How long the battle of a dropped player waits for the player to come
+// back before it is forfeited, 0 to forfeit at once
+var RECONNECT_GRACE = 2 * time.Minute
+
+// How long players in a battle get to finish it after the shutdown notice
+var SHUTDOWN_GRACE = 10 * time.Second
+
+// Game modes, as typed by the player
+const (
+	MODE_POKEBAT = 1
+	MODE_POKECAT = 2
+)
+
+// A Pokémon of a player's collection
+type PlayerPokemon struct {
+	pokedata.Pokemon
+	Level      int     `json:"level"`
+	AccumExp   int     `json:"accum_exp"`
+	Deployable bool    `json:"deployable"`
+	EVPoints   float64 `json:"EVPoints"`
+}
+
+// An entry of players.json, ID is the server.go account it belongs to
+type Player struct {
+	ID          string          `json:"id,omitempty"`
+	Name        string          `json:"name"`
+	PokemonList []PlayerPokemon `json:"pokemon_list"`
+}
+
+// An entry of server.go's accounts.json
+type Account struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"`
+}
+
+var pokedex []pokedata.Pokemon
+var spawns *pokegame.SpawnTable
+
+// Moves of the Pokémon, empty without the files
+var moveset pokegame.Moveset
+
+// Players by account ID. Collections saved before accounts have no ID and
+// wait in unclaimed, by lowercase name, until that account logs in.
+var playersMu sync.Mutex
+var players = map[string]Player{}
+var unclaimed = map[string]Player{}
+
+func main() {
+	configFile := flag.String("config", "game.yaml", "YAML config file, may be missing unless set explicitly")
+	flag.StringVar(&GAME_ADDR, "addr", GAME_ADDR, "address of the game server")
+	flag.StringVar(&DATA_FILE, "data", DATA_FILE, "Pokedex written by crawler.go")
+	flag.StringVar(&PLAYERS_FILE, "players", PLAYERS_FILE, "collections of the players")
+	flag.StringVar(&ACCOUNTS_FILE, "accounts", ACCOUNTS_FILE, "accounts of server.go the players log in with")
+	flag.StringVar(&TEAMS_DIR, "teams", TEAMS_DIR, "teams saved by server.go, its -players directory")
+	flag.StringVar(&MOVES_FILE, "moves", MOVES_FILE, "moves written by main.go")
+	flag.StringVar(&LEARNSETS_FILE, "learnsets", LEARNSETS_FILE, "learnsets written by main.go")
+	flag.IntVar(&TEAM_SIZE, "team-size", TEAM_SIZE, "number of Pokemon each side brings to a battle")
+	flag.StringVar(&DEFAULT_AI, "ai", DEFAULT_AI, "computer trainer of lobbies created without a level: "+strings.Join(pokegame.AI_LEVELS, ", "))
+	flag.Int64Var(&RANDOM_SEED, "seed", RANDOM_SEED, "seed of the battles' random numbers, 0 for a new seed every time")
+	flag.Float64Var(&ENCOUNTER_RATE, "encounter-rate", ENCOUNTER_RATE, "chance of a wild Pokemon on every step in a spawn region, from 0 to 1")
+	flag.StringVar(&MAP_DIR, "maps", MAP_DIR, "directory of the POKECAT maps")
+	flag.StringVar(&START_MAP, "start-map", START_MAP, "map new POKECAT players start on")
+	flag.StringVar(&SPAWNS_FILE, "spawns", SPAWNS_FILE, "spawn table of the POKECAT regions")
+	flag.IntVar(&SPAWN_HOUR, "spawn-hour", SPAWN_HOUR, "hour of the day for spawns, -1 for the clock")
+	spawnSample := flag.Int("spawn-sample", 0, "print this many spawns of every region and exit, with -seed for the same ones every time")
+	flag.DurationVar(&READ_TIMEOUT, "read-timeout", READ_TIMEOUT, "drop players that send nothing for this long, 0 to disable")
+	flag.DurationVar(&WRITE_TIMEOUT, "write-timeout", WRITE_TIMEOUT, "fail writes that take longer than this, 0 to disable")
+	flag.DurationVar(&SHUTDOWN_GRACE, "shutdown-grace", SHUTDOWN_GRACE, "time players in a battle get to finish it on shutdown")
+	flag.DurationVar(&RECONNECT_GRACE, "reconnect-grace", RECONNECT_GRACE, "how long the battle of a dropped player waits for a reconnect, 0 to forfeit at once")
+	flag.Parse()
+
+	loaded, err := pokeconfig.Load(*configFile, "POKEMON_GAME_")
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if loaded != "" {
+		log.Printf("Loaded config from %s\n", loaded)
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	if err := loadGameData(); err != nil {
+		log.Fatalf("Failed to load data: %v", err)
+	}
+	log.Printf("Loaded %d Pokemon and %d players\n", len(pokedex), len(players)+len(unclaimed))
+
+	table, err := pokegame.LoadSpawnTable(SPAWNS_FILE, pokedex, ENCOUNTER_RATE)
+	if err != nil {
+		log.Fatalf("Invalid spawn table: %v", err)
+	}
+	spawns = table
+	if *spawnSample > 0 {
+		printSpawnSample(*spawnSample)
+		return
+	}
+
+	maps, err = pokegame.LoadMaps(MAP_DIR, START_MAP, spawns)
+	if err != nil {
+		log.Fatalf("Invalid maps: %v", err)
+	}
+	log.Printf("Loaded %d maps\n", len(maps))
+
+	listener, err := net.Listen("tcp", GAME_ADDR)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	log.Printf("Game server listening on %s\n", GAME_ADDR)
+
+	// Shut down cleanly on Ctrl+C or SIGTERM
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down\n", sig)
+		shutdown(listener)
+		close(stopped)
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if isShuttingDown() {
+				break
+			}
+			log.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		go handleGame(conn)
+	}
+	<-stopped
+}
+
+func validateConfig() error {
+	switch {
+	case GAME_ADDR == "":
+		return fmt.Errorf("-addr is required")
+	case DATA_FILE == "" || PLAYERS_FILE == "" || ACCOUNTS_FILE == "" || TEAMS_DIR == "":
+		return fmt.Errorf("-data, -players, -accounts and -teams are required")
+	case MOVES_FILE == "" || LEARNSETS_FILE == "":
+		return fmt.Errorf("-moves and -learnsets are required")
+	case TEAM_SIZE < 1 || TEAM_SIZE > 6:
+		return fmt.Errorf("-team-size must be from 1 to 6")
+	case pokegame.AI_TRAINERS[DEFAULT_AI] == nil:
+		return fmt.Errorf("unknown -ai %q, choose one of %s", DEFAULT_AI, strings.Join(pokegame.AI_LEVELS, ", "))
+	case ENCOUNTER_RATE < 0 || ENCOUNTER_RATE > 1:
+		return fmt.Errorf("-encounter-rate must be from 0 to 1")
+	case SPAWNS_FILE == "" || MAP_DIR == "" || START_MAP == "":
+		return fmt.Errorf("-spawns, -maps and -start-map are required")
+	case SPAWN_HOUR < -1 || SPAWN_HOUR > 23:
+		return fmt.Errorf("-spawn-hour must be from 0 to 23, or -1 for the clock")
+	case READ_TIMEOUT < 0 || WRITE_TIMEOUT < 0 || RECONNECT_GRACE < 0:
+		return fmt.Errorf("timeouts must not be negative")
+	case SHUTDOWN_GRACE <= 0:
+		return fmt.Errorf("-shutdown-grace must be positive")
+	}
+	return nil
+}
+
+// loadGameData reads the pokedex and the players. players.json keeps every
+// saved version of a player, the last one wins.
+func loadGameData() error {
+	data, err := ioutil.ReadFile(DATA_FILE)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &pokedex); err != nil {
+		return fmt.Errorf("%s: %w", DATA_FILE, err)
+	}
+	if len(pokedex) == 0 {
+		return fmt.Errorf("%s has no Pokemon", DATA_FILE)
+	}
+	if err := loadMoves(); err != nil {
+		return err
+	}
+
+	data, err = ioutil.ReadFile(PLAYERS_FILE)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []Player
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %w", PLAYERS_FILE, err)
+	}
+	for _, player := range saved {
+		if player.ID == "" {
+			unclaimed[strings.ToLower(player.Name)] = player
+		} else {
+			players[player.ID] = player
+		}
+	}
+	return nil
+}
+
+// loadMoves reads moves.json and learnsets.json. Both are optional, but
+// only together.
+func loadMoves() error {
+	movesData, err := ioutil.ReadFile(MOVES_FILE)
+	if os.IsNotExist(err) {
+		log.Printf("No %s, Pokemon attack with their types\n", MOVES_FILE)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	learnsetsData, err := ioutil.ReadFile(LEARNSETS_FILE)
+	if err != nil {
+		return fmt.Errorf("%s needs %s: %w", MOVES_FILE, LEARNSETS_FILE, err)
+	}
+
+	var moves []pokegame.Move
+	if err := json.Unmarshal(movesData, &moves); err != nil {
+		return fmt.Errorf("%s: %w", MOVES_FILE, err)
+	}
+	var list []pokegame.Learnset
+	if err := json.Unmarshal(learnsetsData, &list); err != nil {
+		return fmt.Errorf("%s: %w", LEARNSETS_FILE, err)
+	}
+	moveset = pokegame.NewMoveset(moves, list)
+	log.Printf("Loaded %d attacking moves and %d learnsets\n", len(moveset.Moves), len(moveset.Learnsets))
+	return nil
+}
+
+func findPlayer(account Account) (Player, bool) {
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	player, ok := players[account.ID]
+	return player, ok
+}
+
+var errWrongLogin = errors.New("wrong name or password")
+
+// login checks a name and password against accounts.json. The file is read
+// every time, server.go adds accounts while the game runs.
+func login(name, password string) (Account, error) {
+	data, err := ioutil.ReadFile(ACCOUNTS_FILE)
+	if os.IsNotExist(err) {
+		return Account{}, errWrongLogin
+	}
+	if err != nil {
+		return Account{}, err
+	}
+	var list []Account
+	if err := json.Unmarshal(data, &list); err != nil {
+		return Account{}, fmt.Errorf("%s: %w", ACCOUNTS_FILE, err)
+	}
+
+	for _, account := range list {
+		if strings.EqualFold(account.Name, name) {
+			if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+				break
+			}
+			claimPlayer(account)
+			return account, nil
+		}
+	}
+	return Account{}, errWrongLogin
+}
+
+// claimPlayer moves a collection saved under the account's name before
+// players had IDs to the account
+func claimPlayer(account Account) {
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	key := strings.ToLower(account.Name)
+	player, ok := unclaimed[key]
+	if !ok {
+		return
+	}
+	if _, exists := players[account.ID]; exists {
+		return
+	}
+	delete(unclaimed, key)
+	player.ID = account.ID
+	players[account.ID] = player
+	if err := savePlayers(); err != nil {
+		log.Printf("Failed to save %s's collection: %v\n", account.Name, err)
+	}
+}
+
+/* Shutdown */
+
+var shutdownMu sync.Mutex
+var shuttingDown bool
+
+// Every running handleGame, and whether its player is in a battle
+var activeGames sync.WaitGroup
+var connsMu sync.Mutex
+var conns = map[*gameConn]bool{}
+
+func isShuttingDown() bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	return shuttingDown
+}
+
+// beginGame counts a new player, unless the server is shutting down
+func beginGame(c *gameConn) bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if shuttingDown {
+		return false
+	}
+	activeGames.Add(1)
+	connsMu.Lock()
+	conns[c] = false
+	connsMu.Unlock()
+	return true
+}
+
+func endGame(c *gameConn) {
+	connsMu.Lock()
+	delete(conns, c)
+	connsMu.Unlock()
+	activeGames.Done()
+}
+
+func setBattling(c *gameConn, battling bool) {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	if _, ok := conns[c]; ok {
+		conns[c] = battling
+	}
+}
+
+// shutdown stops accepting players, sends away the ones outside a battle,
+// gives the others SHUTDOWN_GRACE to finish theirs and closes what is left.
+// Battles cut short are not held for a reconnect.
+func shutdown(listener net.Listener) {
+	shutdownMu.Lock()
+	shuttingDown = true
+	shutdownMu.Unlock()
+	listener.Close()
+
+	connsMu.Lock()
+	for c, battling := range conns {
+		if battling {
+			c.send("The server is shutting down, finish your battle within %s\n", SHUTDOWN_GRACE)
+			continue
+		}
+		c.send("The server is shutting down, bye\n")
+		c.conn.Close()
+	}
+	log.Printf("Waiting for %d players\n", len(conns))
+	connsMu.Unlock()
+
+	if !waitGames(SHUTDOWN_GRACE) {
+		// Closing the connections unblocks every pending read
+		connsMu.Lock()
+		for c := range conns {
+			c.conn.Close()
+		}
+		connsMu.Unlock()
+		waitGames(SHUTDOWN_GRACE)
+	}
+
+	// Catches are saved right away, write the players once more to be safe
+	playersMu.Lock()
+	if len(players)+len(unclaimed) > 0 {
+		if err := savePlayers(); err != nil {
+			log.Printf("Failed to save players: %v", err)
+		}
+	}
+	playersMu.Unlock()
+
+	log.Println("Game server stopped")
+}
+
+// waitGames reports whether every player left within timeout
+func waitGames(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		activeGames.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func newRand() *rand.Rand {
+	seed := RANDOM_SEED
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+/* Connections */
+
+// gameConn reads the player's lines and writes '#'-terminated messages
+type gameConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// send writes one message. A '#' inside would end it early, so names and
+// other text can't contain one.
+func (c *gameConn) send(format string, args ...any) error {
+	if WRITE_TIMEOUT > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	}
+	message := strings.ReplaceAll(fmt.Sprintf(format, args...), "#", "")
+	_, err := c.conn.Write([]byte(message + "#"))
+	return err
+}
+
+func (c *gameConn) readLine() (string, error) {
+	line, err := c.readRawLine()
+	return strings.TrimSpace(line), err
+}
+
+// readRawLine keeps the spaces readLine trims, a password may have them
+func (c *gameConn) readRawLine() (string, error) {
+	if READ_TIMEOUT > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func handleGame(conn net.Conn) {
+	defer conn.Close()
+	// One player's bad input must not take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic with %s: %v", conn.RemoteAddr(), r)
+		}
+	}()
+
+	c := &gameConn{conn: conn, reader: bufio.NewReader(conn)}
+	if !beginGame(c) {
+		c.send("The server is shutting down\n")
+		return
+	}
+	defer endGame(c)
+
+	hello, err := c.readLine()
+	if err != nil {
+		return
+	}
+	var name string
+	var mode int
+	if _, err := fmt.Sscan(hello, &name, &mode); err != nil {
+		c.send("Type following syntax: [Username] [Mode Game]\n")
+		return
+	}
+	password, err := c.readRawLine()
+	if err != nil {
+		return
+	}
+	account, err := login(name, password)
+	if err != nil {
+		if !errors.Is(err, errWrongLogin) {
+			log.Printf("Failed to log in %s: %v\n", name, err)
+		}
+		c.send("Wrong name or password, register with client.go first\n")
+		return
+	}
+	log.Printf("%s joined mode %d from %s\n", account.Name, mode, conn.RemoteAddr())
+	defer log.Printf("%s left\n", account.Name)
+
+	switch mode {
+	case MODE_POKEBAT:
+		err = playPokebat(c, account)
+	case MODE_POKECAT:
+		err = playPokecat(c, account)
+	default:
+		err = c.send("Unknown mode %d, choose 1 for POKEBAT or 2 for POKECAT\n", mode)
+	}
+	if err != nil {
+		log.Printf("%s: %v\n", account.Name, err)
+	}
+}
+
+/* POKEBAT */
+
+// playPokebat is the lobby: the player creates a lobby against a computer
+// trainer, battles, and comes back here
+func playPokebat(c *gameConn, account Account) error {
+	lobbyHelp := fmt.Sprintf("Create a lobby with 'ai [%s]' (default %s), or 'quit'\n", strings.Join(pokegame.AI_LEVELS, "|"), DEFAULT_AI)
+	if fight := resumeBattle(account); fight != nil {
+		if err := c.send("Welcome back, %s, your battle goes on\n%s", account.Name, fight.b.Status(0)); err != nil {
+			holdBattle(account, fight)
+			return err
+		}
+		if err := fightAI(c, account, fight); err != nil {
+			return err
+		}
+		if isShuttingDown() {
+			return c.send("The server is shutting down, bye\n")
+		}
+		if err := c.send("Back in the lobby\n%s", lobbyHelp); err != nil {
+			return err
+		}
+	} else if err := c.send("Welcome to POKEBAT, %s\n%s", account.Name, lobbyHelp); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		args := strings.Fields(line)
+		switch {
+		case len(args) == 0:
+			continue
+		case args[0] == "quit":
+			return c.send("Bye\n")
+		case args[0] == "ai" && len(args) <= 2:
+			level := DEFAULT_AI
+			if len(args) == 2 {
+				level = args[1]
+			}
+			newAI := pokegame.AI_TRAINERS[level]
+			if newAI == nil {
+				err = c.send("Unknown level %q\n%s", level, lobbyHelp)
+				break
+			}
+			if err := battleAI(c, account, level, newAI); err != nil {
+				return err
+			}
+			if isShuttingDown() {
+				return c.send("The server is shutting down, bye\n")
+			}
+			err = c.send("Back in the lobby\n%s", lobbyHelp)
+		default:
+			err = c.send("%s", lobbyHelp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// aiBattle is a battle of the player, side 0, against a computer trainer
+type aiBattle struct {
+	b  *pokegame.Battle
+	ai pokegame.TrainerAI
+}
+
+// battleAI starts a battle of the player against a computer trainer
+func battleAI(c *gameConn, account Account, level string, newAI func(rng *rand.Rand) pokegame.TrainerAI) error {
+	rng := newRand()
+	team, loaned := playerTeam(account, rng)
+	if loaned {
+		if err := c.send("You have no Pokemon yet, you battle with a loaned team\n"); err != nil {
+			return err
+		}
+	}
+
+	opponent := &pokegame.Trainer{Name: "AI (" + level + ")", Team: generateTeam(rng, len(team), averageLevel(team))}
+	fight := &aiBattle{b: pokegame.NewBattle(&pokegame.Trainer{Name: account.Name, Team: team}, opponent, rng), ai: newAI(rng)}
+	if err := c.send("%s wants to battle!\n%s", opponent.Name, fight.b.Status(0)); err != nil {
+		if !isShuttingDown() {
+			holdBattle(account, fight)
+		}
+		return err
+	}
+	return fightAI(c, account, fight)
+}
+
+// fightAI plays the battle to its end. When the connection drops the battle
+// is held for the player to resume.
+func fightAI(c *gameConn, account Account, fight *aiBattle) error {
+	setBattling(c, true)
+	defer setBattling(c, false)
+	err := fightTurns(c, fight)
+	if err != nil && !isShuttingDown() {
+		holdBattle(account, fight)
+	}
+	return err
+}
+
+func fightTurns(c *gameConn, fight *aiBattle) error {
+	b, ai := fight.b, fight.ai
+	for b.Winner() < 0 {
+		var mine pokegame.Action
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		mine, err = pokegame.ParseAction(b, 0, line)
+		if err != nil {
+			if err := c.send("%v\n%s", err, b.ActionsHelp(0)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A fainted Pokémon is replaced before the next turn
+		if b.Sides[0].Fighter().Fainted() {
+			b.Sides[0].Active = mine.Index
+			if err := c.send("%s sends out %s\n%s", b.Sides[0].Name, b.Sides[0].Fighter().Name, b.Status(0)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		events := b.Play([2]pokegame.Action{mine, ai.Choose(b, 1)})
+		if b.Winner() < 0 && b.Sides[1].Fighter().Fainted() {
+			b.Sides[1].Active = ai.Replace(b, 1)
+			events = append(events, fmt.Sprintf("%s sends out %s", b.Sides[1].Name, b.Sides[1].Fighter().Name))
+		}
+		if winner := b.Winner(); winner >= 0 {
+			events = append(events, b.Sides[winner].Name+" wins!")
+		}
+		if err := c.send("%s\n%s", strings.Join(events, "\n"), b.Status(0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Battles of dropped players by account ID, until they come back or
+// RECONNECT_GRACE runs out
+var heldMu sync.Mutex
+var heldBattles = map[string]*heldBattle{}
+
+type heldBattle struct {
+	fight *aiBattle
+	timer *time.Timer
+}
+
+// holdBattle keeps an unfinished battle for the player to resume, the
+// player forfeits it after RECONNECT_GRACE
+func holdBattle(account Account, fight *aiBattle) {
+	if fight.b.Winner() >= 0 {
+		return
+	}
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	held := &heldBattle{fight: fight}
+	held.timer = time.AfterFunc(RECONNECT_GRACE, func() {
+		heldMu.Lock()
+		defer heldMu.Unlock()
+		if heldBattles[account.ID] == held {
+			delete(heldBattles, account.ID)
+			fight.b.Forfeit = 0
+			log.Printf("%s did not come back and forfeits the battle against %s\n", account.Name, fight.b.Sides[1].Name)
+		}
+	})
+	heldBattles[account.ID] = held
+	log.Printf("%s dropped out of a battle, waiting %s for a reconnect\n", account.Name, RECONNECT_GRACE)
+}
+
+// resumeBattle takes the held battle of the player, nil for none
+func resumeBattle(account Account) *aiBattle {
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	held, ok := heldBattles[account.ID]
+	if !ok {
+		return nil
+	}
+	held.timer.Stop()
+	delete(heldBattles, account.ID)
+	log.Printf("%s is back in the battle against %s\n", account.Name, held.fight.b.Sides[1].Name)
+	return held.fight
+}
+
+/* POKECAT */
+
+// How entities are drawn over the map
+const (
+	SYMBOL_PLAYER   = '@'
+	SYMBOL_EXPLORER = '&'
+)
+
+// Directory of the POKECAT maps and the map new players start on
+var MAP_DIR = "maps"
+var START_MAP = "route1"
+
+var maps map[string]*pokegame.Map
+
+// explorer is a player walking a map, players see each other as entities
+type explorer struct {
+	Name string
+	Map  string
+	X, Y int
+}
+
+var explorersMu sync.Mutex
+var explorers = map[*explorer]bool{}
+
+func moveExplorer(e *explorer, mapID string, x, y int) {
+	explorersMu.Lock()
+	defer explorersMu.Unlock()
+	e.Map, e.X, e.Y = mapID, x, y
+	explorers[e] = true
+}
+
+func removeExplorer(e *explorer) {
+	explorersMu.Lock()
+	defer explorersMu.Unlock()
+	delete(explorers, e)
+}
+
+// entitiesOf is what an explorer sees: the others on its map, then itself
+// on top
+func entitiesOf(me *explorer) []pokegame.Entity {
+	explorersMu.Lock()
+	defer explorersMu.Unlock()
+	var entities []pokegame.Entity
+	for e := range explorers {
+		if e != me && e.Map == me.Map {
+			entities = append(entities, pokegame.Entity{X: e.X, Y: e.Y, Symbol: SYMBOL_EXPLORER})
+		}
+	}
+	return append(entities, pokegame.Entity{X: me.X, Y: me.Y, Symbol: SYMBOL_PLAYER})
+}
+
+// Keys of player.go, letters or arrow names
+var MOVES = map[string][2]int{
+	"w": {0, -1}, "up": {0, -1},
+	"s": {0, 1}, "down": {0, 1},
+	"a": {-1, 0}, "left": {-1, 0},
+	"d": {1, 0}, "right": {1, 0},
+}
+
+// Keys of a wild battle, digits attack with the move of that number
+const (
+	KEY_BALL   = "b"
+	KEY_SWITCH = "x"
+	KEY_RUN    = "r"
+	KEY_QUIT   = "q"
+)
+
+// playPokecat lets the player walk the maps, spawn tiles lead to wild
+// battles and warps to other maps
+func playPokecat(c *gameConn, account Account) error {
+	name := account.Name
+	rng := newRand()
+	m := maps[START_MAP]
+	me := &explorer{Name: name}
+	moveExplorer(me, m.ID, m.Starts[0][0], m.Starts[0][1])
+	defer removeExplorer(me)
+
+	message := fmt.Sprintf("Welcome to POKECAT, %s", name)
+	for {
+		frame := pokegame.RenderFrame(m, entitiesOf(me), "WASD or arrows to move, q to quit", message)
+		if err := c.send("%s", frame); err != nil {
+			return err
+		}
+		message = ""
+
+		key, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if key == KEY_QUIT || key == "esc" {
+			return c.send("Bye\n")
+		}
+		move, ok := MOVES[key]
+		if !ok {
+			continue
+		}
+		x, y := me.X+move[0], me.Y+move[1]
+		if !pokegame.Walkable(m.Tile(x, y)) {
+			continue
+		}
+		moveExplorer(me, m.ID, x, y)
+
+		if w, ok := m.WarpAt(x, y); ok {
+			m = maps[w.To]
+			start := m.Starts[w.Start]
+			moveExplorer(me, m.ID, start[0], start[1])
+			message = "You enter " + m.Title
+			continue
+		}
+
+		region, spawning := m.Region(x, y)
+		if !spawning {
+			continue
+		}
+		species, level, ok := spawns.Spawn(region, rng, spawnHour())
+		if ok {
+			message, err = wildBattle(c, account, rng, species, level)
+			if err != nil {
+				return err
+			}
+			if isShuttingDown() {
+				return c.send("%s\nThe server is shutting down, bye\n", message)
+			}
+		}
+	}
+}
+
+// wildBattle fights a wild Pokémon until it faints, is caught, or one side
+// gets away. It returns what happened for the next frame.
+func wildBattle(c *gameConn, account Account, rng *rand.Rand, species pokedata.Pokemon, level int) (string, error) {
+	setBattling(c, true)
+	defer setBattling(c, false)
+	name := account.Name
+	team, _ := playerTeam(account, rng)
+	wild := &pokegame.Trainer{Name: species.Name, Team: []*pokegame.Fighter{pokegame.NewFighter(species, level, moveset)}, Wild: true}
+	b := pokegame.NewBattle(&pokegame.Trainer{Name: name, Team: team}, wild, rng)
+	wildAI := pokegame.AI_TRAINERS["random"](rng)
+
+	events := []string{fmt.Sprintf("A wild %s appeared!", species.Name)}
+	fleeAttempts := 0
+	for {
+		if err := c.send("%s\n%s", strings.Join(events, "\n"), wildStatus(b)); err != nil {
+			return "", err
+		}
+		events = nil
+
+		key, err := c.readLine()
+		if err != nil {
+			return "", err
+		}
+		mine := pokegame.Action{Kind: pokegame.ACTION_NONE}
+		switch {
+		case key == KEY_BALL:
+			if ownedPokemon(account, species.Name) {
+				events = append(events, "You already have a "+species.Name)
+				continue
+			}
+			chance := pokegame.CatchChance(wild.Fighter(), species.Exp)
+			if rng.Float64() < chance {
+				if err := capturePokemon(account, species, wild.Fighter().Level, rng); err != nil {
+					log.Printf("Failed to save %s's %s: %v\n", name, species.Name, err)
+					return "The ball caught " + species.Name + " but it could not be saved", nil
+				}
+				return fmt.Sprintf("Gotcha! %s was caught and added to your collection", species.Name), nil
+			}
+			events = append(events, fmt.Sprintf("You threw a ball... %s broke free! (%.0f%% chance)", species.Name, chance*100))
+
+		case key == KEY_RUN:
+			fleeAttempts++
+			if rng.Float64() < pokegame.FleeChance(b.Sides[0].Fighter(), wild.Fighter(), fleeAttempts) {
+				return "Got away safely", nil
+			}
+			events = append(events, "You couldn't get away!")
+
+		case key == KEY_SWITCH:
+			next := pokegame.NextAlive(b.Sides[0])
+			if next < 0 {
+				events = append(events, "No other Pokemon can fight")
+				continue
+			}
+			mine = pokegame.Action{Kind: pokegame.ACTION_SWITCH, Index: next}
+
+		case len(key) == 1 && key[0] >= '1' && key[0] <= '9':
+			index := int(key[0] - '1')
+			if index >= len(b.Sides[0].Fighter().Moves) {
+				continue
+			}
+			mine = pokegame.Action{Kind: pokegame.ACTION_ATTACK, Index: index}
+
+		default:
+			continue
+		}
+
+		events = append(events, b.Play([2]pokegame.Action{mine, wildAI.Choose(b, 1)})...)
+		switch {
+		case wild.Defeated():
+			return "You defeated the wild " + species.Name, nil
+		case b.Sides[0].Defeated():
+			return "All your Pokemon fainted, you hurry back to safety", nil
+		case b.Sides[0].Fighter().Fainted():
+			b.Sides[0].Active = pokegame.NextAlive(b.Sides[0])
+			events = append(events, fmt.Sprintf("%s sends out %s", name, b.Sides[0].Fighter().Name))
+		}
+	}
+}
+
+func wildStatus(b *pokegame.Battle) string {
+	mine := b.Sides[0].Fighter()
+	var attacks []string
+	for i, m := range mine.Moves {
+		attacks = append(attacks, fmt.Sprintf("%d %s", i+1, m.Name))
+	}
+	return fmt.Sprintf("Wild: %s\nYou:  %s\nAttack: %s | b ball | x switch | r run\n",
+		pokegame.FighterLine(b.Sides[1].Fighter()), pokegame.FighterLine(mine), strings.Join(attacks, ", "))
+}
+
+/* Spawns */
+
+func spawnHour() int {
+	if SPAWN_HOUR >= 0 {
+		return SPAWN_HOUR
+	}
+	return time.Now().Hour()
+}
+
+// printSpawnSample prints spawns of every region as they would happen now
+func printSpawnSample(count int) {
+	rng := newRand()
+	hour := spawnHour()
+	var regions []string
+	for name := range spawns.Regions {
+		regions = append(regions, name)
+	}
+	sort.Strings(regions)
+
+	for _, name := range regions {
+		if spawns.Rate(name) == 0 {
+			fmt.Printf("%s: disabled\n", name)
+			continue
+		}
+		// Every roll is an encounter in the sample
+		var sample []string
+		for i := 0; i < count; i++ {
+			species, level, ok := spawns.Pick(name, rng, hour)
+			if ok {
+				sample = append(sample, fmt.Sprintf("%s Lv %d", species.Name, level))
+			}
+		}
+		fmt.Printf("%s (%s): %s\n", name, pokegame.PeriodOf(hour), strings.Join(sample, ", "))
+	}
+}
+
+/* Collections */
+
+func ownedPokemon(account Account, pokemonName string) bool {
+	player, _ := findPlayer(account)
+	for _, p := range player.PokemonList {
+		if p.Name == pokemonName {
+			return true
+		}
+	}
+	return false
+}
+
+// capturePokemon adds a caught Pokémon to the player's collection and saves
+// players.json. Like every caught Pokémon its stats are 50% to 100% of the
+// species' base stats, EVPoints records how much.
+func capturePokemon(account Account, species pokedata.Pokemon, level int, rng *rand.Rand) error {
+	ev := 0.5 + rng.Float64()*0.5
+	caught := PlayerPokemon{Pokemon: species, Level: level, EVPoints: math.Round(ev*100) / 100}
+	caught.Exp = int(float64(caught.Exp) * ev)
+	caught.SpDefense = int(float64(caught.SpDefense) * ev)
+	caught.SpAttack = int(float64(caught.SpAttack) * ev)
+	caught.Defense = int(float64(caught.Defense) * ev)
+	caught.Attack = int(float64(caught.Attack) * ev)
+	caught.HP = int(float64(caught.HP) * ev)
+
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	player := players[account.ID]
+	player.ID, player.Name = account.ID, account.Name
+	player.PokemonList = append(append([]PlayerPokemon(nil), player.PokemonList...), caught)
+	players[account.ID] = player
+	return savePlayers()
+}
+
+// savePlayers writes one entry per player, sorted by name. The caller holds
+// playersMu.
+func savePlayers() error {
+	saved := make([]Player, 0, len(players)+len(unclaimed))
+	for _, player := range players {
+		saved = append(saved, player)
+	}
+	for _, player := range unclaimed {
+		saved = append(saved, player)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+
+	data, err := json.MarshalIndent(saved, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := PLAYERS_FILE + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, PLAYERS_FILE)
+}
+
+/* Teams */
+
+// playerTeam is the team the player saved on server.go, or else the
+// deployable Pokémon of the collection first, then the rest of it. Players
+// without either get a loaned team.
+func playerTeam(account Account, rng *rand.Rand) ([]*pokegame.Fighter, bool) {
+	player, ok := findPlayer(account)
+	saved, err := savedTeam(account)
+	if err != nil {
+		log.Printf("Ignoring the saved team of %s: %v\n", account.Name, err)
+	}
+	if len(saved) > 0 {
+		var team []*pokegame.Fighter
+		for _, species := range saved {
+			if len(team) == TEAM_SIZE {
+				break
+			}
+			team = append(team, teamMember(player, species))
+		}
+		return team, false
+	}
+
+	if !ok || len(player.PokemonList) == 0 {
+		return generateTeam(rng, TEAM_SIZE, pokegame.MIN_LEVEL), true
+	}
+
+	collection := append([]PlayerPokemon(nil), player.PokemonList...)
+	sort.SliceStable(collection, func(i, j int) bool {
+		return collection[i].Deployable && !collection[j].Deployable
+	})
+	var team []*pokegame.Fighter
+	for _, p := range collection {
+		if len(team) == TEAM_SIZE {
+			break
+		}
+		team = append(team, pokegame.NewFighter(p.Pokemon, p.Level, moveset))
+	}
+	return team, false
+}
+
+// savedTeam reads the team server.go saved for the account, nil when there
+// is none. The file only names the Pokémon, their stats come from our
+// pokedex.
+func savedTeam(account Account) ([]pokedata.Pokemon, error) {
+	data, err := ioutil.ReadFile(filepath.Join(TEAMS_DIR, account.ID+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved struct {
+		Selected []struct {
+			Name string `json:"name"`
+			ID   int    `json:"id"`
+		}
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	var team []pokedata.Pokemon
+	for _, member := range saved.Selected {
+		species, ok := findSpecies(member.Name, member.ID)
+		if !ok {
+			return nil, fmt.Errorf("%s is not in %s", member.Name, DATA_FILE)
+		}
+		team = append(team, species)
+	}
+	return team, nil
+}
+
+// findSpecies matches PokéAPI names like "raichu-alola" to ours like
+// "Raichu (Alola)", and the national dex number to the default form
+func findSpecies(name string, id int) (pokedata.Pokemon, bool) {
+	key := func(name string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(name))
+	}
+	for _, p := range pokedex {
+		if key(p.Name) == key(name) {
+			return p, true
+		}
+	}
+	for _, p := range pokedex {
+		if id > 0 && p.Index == strconv.Itoa(id) && p.Form == "" {
+			return p, true
+		}
+	}
+	return pokedata.Pokemon{}, false
+}
+
+// teamMember fights as the player caught it, or at pokegame.MIN_LEVEL when the
+// species is not in the collection
+func teamMember(player Player, species pokedata.Pokemon) *pokegame.Fighter {
+	for _, p := range player.PokemonList {
+		if p.Name == species.Name {
+			return pokegame.NewFighter(p.Pokemon, p.Level, moveset)
+		}
+	}
+	return pokegame.NewFighter(species, pokegame.MIN_LEVEL, moveset)
+}
+
+func averageLevel(team []*pokegame.Fighter) int {
+	total := 0
+	for _, f := range team {
+		total += f.Level
+	}
+	return total / len(team)
+}
+
+// generateTeam picks a random team at the given level. Low levels only get
+// weaker species, ranked by their total base stats.
+func generateTeam(rng *rand.Rand, size int, level int) []*pokegame.Fighter {
+	maxTotal := 300 + 4*level
+	var candidates []pokedata.Pokemon
+	for _, p := range pokedex {
+		if p.TotalEVs > 0 && p.TotalEVs <= maxTotal {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) < size {
+		candidates = pokedex
+	}
+
+	var team []*pokegame.Fighter
+	for _, i := range rng.Perm(len(candidates)) {
+		if len(team) == size {
+			break
+		}
+		team = append(team, pokegame.NewFighter(candidates[i], level, moveset))
+	}
+	return team
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"pokemonproject/server/pokedata"
 )
 
 type pokemon struct {
@@ -29,47 +33,206 @@ type pokemon struct {
 	FromLevel      int      `json:"from_level"`
 	To             int      `json:"to"`
 	ToLevel        int      `json:"to_level"`
+	// Every evolution out of this species; To/ToLevel only hold the first
+	Evolutions []pokedata.Evolution `json:"evolutions"`
+	// From the supplemental and description assets
+	Description string `json:"description,omitempty"`
+	Height      string `json:"height,omitempty"`
+	Weight      string `json:"weight,omitempty"`
+	CatchRate   int    `json:"catch_rate,omitempty"`
+	GrowthRate  string `json:"growth_rate,omitempty"`
+}
+
+// A move as stored in moves.json
+type move struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Power    int    `json:"power"`
+	Accuracy int    `json:"accuracy"`
+	PP       int    `json:"pp"`
+	Category string `json:"category"`
+}
+
+// Level-up moves of one species as stored in learnsets.json
+type learnset struct {
+	ID      int            `json:"national_id"`
+	Name    string         `json:"name"`
+	LevelUp []learnsetMove `json:"level_up"`
+}
+
+type learnsetMove struct {
+	Level int    `json:"level"`
+	Move  string `json:"move"`
+}
+
+// evolutionGraphFromPokedex rebuilds the graph from a saved pokemon.json
+func evolutionGraphFromPokedex(pokemons []pokemon) *pokedata.EvolutionGraph {
+	var entries []pokedata.EvolutionEntry
+	for _, p := range pokemons {
+		entries = append(entries, pokedata.EvolutionEntry{ID: p.ID, From: p.From, FromLevel: p.FromLevel, To: p.To, ToLevel: p.ToLevel, Evolutions: p.Evolutions})
+	}
+	return pokedata.NewEvolutionGraph(pokedata.EvolutionEdges(entries))
+}
+
+// Every document the import downloaded, in order
+var sources pokedata.Sources
+
+// pokedex.org serves its data as PouchDB dumps: a header object followed by
+// batches of {"docs": [...], "seq": n}.
+const assetBaseURL = "https://pokedex.org/assets/"
+
+var (
+	skimMonsterAssets  = []string{"skim-monsters-1.txt", "skim-monsters-2.txt", "skim-monsters-3.txt"}
+	supplementalAssets = []string{"monsters-supplemental-1.txt", "monsters-supplemental-2.txt", "monsters-supplemental-3.txt"}
+	descriptionAssets  = []string{"descriptions-1.txt", "descriptions-2.txt", "descriptions-3.txt"}
+	moveAssets         = []string{"moves-1.txt", "moves-2.txt", "moves-3.txt"}
+	monsterMoveAssets  = []string{"monster-moves-1.txt", "monster-moves-2.txt", "monster-moves-3.txt"}
+	evolutionAsset     = "evolutions.txt"
+	typeAsset          = "types.txt"
+)
+
+// One top level object of a dump file
+type assetBatch struct {
+	Version string            `json:"version"`
+	DBInfo  json.RawMessage   `json:"db_info"`
+	Docs    []json.RawMessage `json:"docs"`
+	Seq     json.RawMessage   `json:"seq"`
+}
+
+// Fields every PouchDB document carries
+type assetDoc struct {
+	ID      string `json:"_id"`
+	Deleted bool   `json:"_deleted"`
+}
+
+type resourceRef struct {
+	Name        string `json:"name"`
+	ResourceURI string `json:"resource_uri"`
+}
+
+type skimMonster struct {
+	Name           string        `json:"name"`
+	NationalID     int           `json:"national_id"`
+	Types          []resourceRef `json:"types"`
+	Attack         int           `json:"attack"`
+	Defense        int           `json:"defense"`
+	SpecialAttack  int           `json:"sp_atk"`
+	SpecialDefense int           `json:"sp_def"`
+	Speed          int           `json:"speed"`
+	HP             int           `json:"hp"`
+	Descriptions   []resourceRef `json:"descriptions"`
+}
+
+type supplementalMonster struct {
+	NationalID int           `json:"national_id"`
+	Abilities  []resourceRef `json:"abilities"`
+	EggGroups  []resourceRef `json:"egg_groups"`
+	CatchRate  int           `json:"catch_rate"`
+	Happiness  int           `json:"happiness"`
+	GrowthRate string        `json:"growth_rate"`
+	Height     string        `json:"height"`
+	Weight     string        `json:"weight"`
+}
+
+type evolutionLink struct {
+	Name       string `json:"name"`
+	NationalID int    `json:"nationalId"`
+	Method     string `json:"method"`
+	Level      int    `json:"level"`
+	Item       string `json:"item"`
+	Detail     string `json:"detail"`
+}
+
+// evolutionLinks accepts a single link object as well as a list of them
+type evolutionLinks []evolutionLink
+
+func (l *evolutionLinks) UnmarshalJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) > 0 && bytes.TrimSpace(data)[0] == '{' {
+		var link evolutionLink
+		if err := json.Unmarshal(data, &link); err != nil {
+			return err
+		}
+		*l = evolutionLinks{link}
+		return nil
+	}
+	var links []evolutionLink
+	if err := json.Unmarshal(data, &links); err != nil {
+		return err
+	}
+	*l = links
+	return nil
+}
+
+type evolutionDoc struct {
+	NationalID int            `json:"-"`
+	From       evolutionLinks `json:"from"`
+	To         evolutionLinks `json:"to"`
+}
+
+type typeDoc struct {
+	Name           string        `json:"name"`
+	Ineffective    []resourceRef `json:"ineffective"`
+	NoEffect       []resourceRef `json:"no_effect"`
+	Resistance     []resourceRef `json:"resistance"`
+	SuperEffective []resourceRef `json:"super_effective"`
+	Weakness       []resourceRef `json:"weakness"`
+}
+
+type descriptionDoc struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Pokemon     resourceRef `json:"pokemon"`
+	NationalID  int         `json:"-"`
+}
+
+type moveDoc struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Power       int    `json:"power"`
+	Accuracy    int    `json:"accuracy"`
+	PP          int    `json:"pp"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
 }
 
-type playerPokemon struct {
-	Name    string  `json:"playername"`
-	Pokemon pokemon `json:"pokemon"`
+type monsterMove struct {
+	Name        string `json:"name"`
+	LearnType   string `json:"learn_type"`
+	Level       int    `json:"level"`
+	ResourceURI string `json:"resource_uri"`
 }
 
-type evolution struct {
-	From      int `json:"from"`
-	To        int `json:"to"`
-	FromLevel int `json:"level"`
-	ToLevel   int `json:"level"`
+type monsterMovesDoc struct {
+	NationalID int           `json:"-"`
+	Moves      []monsterMove `json:"moves"`
+}
+
+// All pokedex.org assets decoded into typed documents
+type assetSet struct {
+	Monsters     []skimMonster
+	Supplemental map[int]supplementalMonster
+	Descriptions map[int]descriptionDoc
+	Evolutions   []evolutionDoc
+	Types        []typeDoc
+	Moves        []moveDoc
+	MonsterMoves map[int]monsterMovesDoc
 }
 
 func main() {
-	/*
-		linkArr := [17]string{
-			"https://pokedex.org/assets/skim-monsters-1.txt",
-			"https://pokedex.org/assets/descriptions-1.txt",
-			"https://pokedex.org/assets/evolutions.txt",
-			"https://pokedex.org/assets/types.txt",
-			"https://pokedex.org/assets/monsters-supplemental-1.txt",
-			"https://pokedex.org/assets/descriptions-2.txt",
-			"https://pokedex.org/assets/monsters-supplemental-2.txt",
-			"https://pokedex.org/assets/skim-monsters-2.txt",
-			"https://pokedex.org/assets/descriptions-3.txt",
-			"https://pokedex.org/assets/monsters-supplemental-3.txt",
-			"https://pokedex.org/assets/skim-monsters-3.txt",
-			"https://pokedex.org/assets/moves-1.txt",
-			"https://pokedex.org/assets/moves-2.txt",
-			"https://pokedex.org/assets/moves-3.txt",
-			"https://pokedex.org/assets/monster-moves-1.txt",
-			"https://pokedex.org/assets/monster-moves-2.txt",
-			"https://pokedex.org/assets/monster-moves-3.txt",
-		}
-	*/
-
-	//crawPokemon()
-
-	// get all pokemon from pokedex.json
-	file, err := os.Open("pokedex.json")
+	dataFile := flag.String("data", "pokemon.json", "Pokemon data file written by -import and read by -chain and the listing")
+	chainOf := flag.String("chain", "", "print the evolution family of a Pokémon, by name or national id")
+	importData := flag.Bool("import", false, "import the data file, moves.json and learnsets.json from pokedex.org first")
+	flag.Parse()
+
+	if *importData {
+		if err := crawPokemon(*dataFile); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	// get all pokemon from the data file
+	file, err := os.Open(*dataFile)
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -84,6 +247,13 @@ func main() {
 		return
 	}
 
+	if *chainOf != "" {
+		if err := printChain(pokemons, *chainOf); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+
 	// Create a map to store the Pokemon data
 	pokemonMap := make(map[string]pokemon)
 
@@ -99,82 +269,131 @@ func main() {
 
 }
 
-func crawPokemon() {
+// printChain prints every evolution of the family of one Pokémon, e.g.
+// "Eevee -> Vaporeon (item: water-stone)"
+func printChain(pokemons []pokemon, key string) error {
+	names := map[int]string{}
+	id := 0
+	for _, p := range pokemons {
+		names[p.ID] = p.Name
+		if strings.EqualFold(p.Name, key) || strconv.Itoa(p.ID) == key {
+			id = p.ID
+		}
+	}
+	if id == 0 {
+		return fmt.Errorf("no Pokémon %q", key)
+	}
 
-	pokemon := getPokemon("https://pokedex.org/assets/skim-monsters-1.txt")
-	pokemon = append(pokemon, getPokemon("https://pokedex.org/assets/skim-monsters-2.txt")...)
-	pokemon = append(pokemon, getPokemon("https://pokedex.org/assets/skim-monsters-3.txt")...)
+	chain := evolutionGraphFromPokedex(pokemons).Chain(id)
+	if len(chain) == 0 {
+		fmt.Printf("%s does not evolve\n", names[id])
+		return nil
+	}
+	for _, e := range chain {
+		condition := e.Trigger
+		switch {
+		case e.Level > 0:
+			condition = fmt.Sprintf("%s %d", e.Trigger, e.Level)
+		case e.Item != "":
+			condition = fmt.Sprintf("%s: %s", e.Trigger, e.Item)
+		}
+		fmt.Printf("%s -> %s (%s)\n", names[e.From], names[e.To], condition)
+	}
+	return nil
+}
 
-	jsonData, err := json.MarshalIndent(pokemon, "", "  ")
+func crawPokemon(dataFile string) error {
+	assets, err := loadAssets()
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return err
 	}
 
-	err = os.WriteFile("pokedex.json", jsonData, 0644)
+	var files []pokedata.File
+
+	pokemon := getPokemon(assets, getEvolutions(assets), getExp())
+	file, err := writeJSONFile(dataFile, pokemon, len(pokemon))
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return err
 	}
-	fmt.Println("Pokemon data saved to pokedex.json")
-
-}
+	files = append(files, file)
+	fmt.Printf("Pokemon data saved to %s\n", dataFile)
 
-func getPokemon(link string) []pokemon {
-	url := link
-	method := "GET"
+	// Moves and learnsets are stored next to the data file
+	moves := getMoves(assets)
+	file, err = writeJSONFile("moves.json", moves, len(moves))
+	if err != nil {
+		return err
+	}
+	files = append(files, file)
+	fmt.Println("Move data saved to moves.json")
 
-	pokemons := []pokemon{}
-	evolutions := getEvolutions()
-	exps := getExp()
+	learnsets := getLearnsets(assets, moves)
+	file, err = writeJSONFile("learnsets.json", learnsets, len(learnsets))
+	if err != nil {
+		return err
+	}
+	files = append(files, file)
+	fmt.Println("Learnset data saved to learnsets.json")
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+	manifestPath := pokedata.ManifestPath(dataFile)
+	manifest := pokedata.NewManifest(pokedata.Producer("main.go"), pokedata.SchemaImport, files, sources)
+	if err := manifest.Write(manifestPath); err != nil {
+		return err
+	}
+	fmt.Printf("Dataset version %s saved to %s\n", manifest.Version, manifestPath)
+	return nil
+}
 
+// writeJSONFile writes data and returns its manifest entry
+func writeJSONFile(filename string, data any, records int) (pokedata.File, error) {
+	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		fmt.Println(err)
-		return pokemons
+		return pokedata.File{}, fmt.Errorf("error marshalling %s: %w", filename, err)
 	}
-	res, err := client.Do(req)
+
+	file, err := pokedata.WriteFile(filename, jsonData, records)
 	if err != nil {
-		fmt.Println(err)
-		return pokemons
+		return file, fmt.Errorf("error writing %s: %w", filename, err)
 	}
-	defer res.Body.Close()
+	return file, nil
+}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return pokemons
-	}
-
-	bodyArr := strings.Split(string(body), "descriptions")
-	bodyArr = bodyArr[1:]
-	for _, v := range bodyArr {
-		pokemon := pokemon{}
-		pokemonName := strings.Split(v, "male_female_ratio")[0]
-		pokemonName = strings.Split(pokemonName, "\"")[len(strings.Split(pokemonName, "\""))-3]
-		pokemon.Name = pokemonName
-		pokemonTypes := getSubstringBetween(v, "types", "attack")
-		pokemonTypesArr := strings.Split(pokemonTypes, "\"name\":\"")
-		pokemonTypesArr = pokemonTypesArr[1:]
-		for _, v := range pokemonTypesArr {
-			pokemon.Type = append(pokemon.Type, strings.Split(v, "\"")[0])
-		}
-		pokemonId := getSubstringBetween(v, "national_id\":", ",")
-		pokemon.ID, _ = strconv.Atoi(pokemonId)
-
-		pokemon.Attack, _ = strconv.Atoi(getSubstringBetween(v, "attack\":", ","))
-		pokemon.Defense, _ = strconv.Atoi(getSubstringBetween(v, "defense\":", ","))
-		pokemon.SpecialAttack, _ = strconv.Atoi(getSubstringBetween(v, "sp_atk\":", ","))
-		pokemon.SpecialDefense, _ = strconv.Atoi(getSubstringBetween(v, "sp_def\":", ","))
-		pokemon.Speed, _ = strconv.Atoi(getSubstringBetween(v, "speed\":", ","))
-		pokemon.HP, _ = strconv.Atoi(getSubstringBetween(v, "hp\":", ","))
-		pokemon.From = evolutions[pokemon.ID].From
-		pokemon.FromLevel = evolutions[pokemon.ID].FromLevel
-		pokemon.To = evolutions[pokemon.ID].To
-		pokemon.ToLevel = evolutions[pokemon.ID].ToLevel
+func getPokemon(assets assetSet, evolutions *pokedata.EvolutionGraph, exps map[int]int) []pokemon {
+	pokemons := []pokemon{}
+
+	for _, monster := range assets.Monsters {
+		pokemon := pokemon{
+			Name:           monster.Name,
+			ID:             monster.NationalID,
+			Attack:         monster.Attack,
+			Defense:        monster.Defense,
+			SpecialAttack:  monster.SpecialAttack,
+			SpecialDefense: monster.SpecialDefense,
+			Speed:          monster.Speed,
+			HP:             monster.HP,
+		}
+		for _, t := range monster.Types {
+			pokemon.Type = append(pokemon.Type, t.Name)
+		}
+		if prev, ok := evolutions.PreviousEvolution(pokemon.ID); ok {
+			pokemon.From = prev.From
+			pokemon.FromLevel = prev.Level
+		}
+		pokemon.Evolutions = evolutions.NextEvolutions(pokemon.ID)
+		if len(pokemon.Evolutions) > 0 {
+			pokemon.To = pokemon.Evolutions[0].To
+			pokemon.ToLevel = pokemon.Evolutions[0].Level
+		}
 		pokemon.Exp = exps[pokemon.ID]
+		if supplemental, ok := assets.Supplemental[pokemon.ID]; ok {
+			pokemon.Height = supplemental.Height
+			pokemon.Weight = supplemental.Weight
+			pokemon.CatchRate = supplemental.CatchRate
+			pokemon.GrowthRate = supplemental.GrowthRate
+		}
+		if description, ok := assets.Descriptions[pokemon.ID]; ok {
+			pokemon.Description = description.Description
+		}
 
 		pokemons = append(pokemons, pokemon)
 	}
@@ -182,61 +401,391 @@ func getPokemon(link string) []pokemon {
 	return pokemons
 }
 
-func getEvolutions() map[int]evolution {
+func getEvolutions(assets assetSet) *pokedata.EvolutionGraph {
+	var edges []pokedata.Evolution
 
-	evolutions := map[int]evolution{}
+	for _, doc := range assets.Evolutions {
+		for _, link := range doc.From {
+			edges = append(edges, newEvolution(link.NationalID, doc.NationalID, link))
+		}
+		for _, link := range doc.To {
+			edges = append(edges, newEvolution(doc.NationalID, link.NationalID, link))
+		}
+	}
 
-	url := "https://pokedex.org/assets/evolutions.txt"
-	method := "GET"
+	return pokedata.NewEvolutionGraph(edges)
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+func newEvolution(from, to int, link evolutionLink) pokedata.Evolution {
+	item := link.Item
+	if item == "" {
+		item = link.Detail
+	}
+	evolution := pokedata.Evolution{From: from, To: to, Level: link.Level, Item: item}
+
+	method := strings.ToLower(link.Method)
+	switch {
+	case strings.Contains(method, "trade"):
+		evolution.Trigger = pokedata.TriggerTrade
+	case strings.Contains(method, "happiness"), strings.Contains(method, "friendship"):
+		evolution.Trigger = pokedata.TriggerFriendship
+	case strings.Contains(method, "stone"), strings.Contains(method, "item"), method == "use":
+		evolution.Trigger = pokedata.TriggerItem
+	case strings.Contains(method, "level"), method == "" && link.Level > 0:
+		evolution.Trigger = pokedata.TriggerLevel
+	default:
+		evolution.Trigger = pokedata.TriggerOther
+	}
+	return evolution
+}
+
+func getMoves(assets assetSet) []move {
+	moves := []move{}
+	seen := map[string]bool{}
+
+	for _, doc := range assets.Moves {
+		key := moveKey(doc.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		moves = append(moves, move{
+			Name:     doc.Name,
+			Type:     strings.ToLower(doc.Type),
+			Power:    doc.Power,
+			Accuracy: doc.Accuracy,
+			PP:       doc.PP,
+			Category: strings.ToLower(doc.Category),
+		})
+	}
+
+	sort.Slice(moves, func(i, j int) bool { return moves[i].Name < moves[j].Name })
+	return moves
+}
+
+// getLearnsets keeps the level-up moves of every species, sorted by level.
+// Moves missing from the moves dataset are reported and left out.
+func getLearnsets(assets assetSet, moves []move) []learnset {
+	names := map[string]string{}
+	for _, m := range moves {
+		names[moveKey(m.Name)] = m.Name
+	}
+
+	learnsets := []learnset{}
+	for _, monster := range assets.Monsters {
+		learnset := learnset{ID: monster.NationalID, Name: monster.Name, LevelUp: []learnsetMove{}}
+
+		for _, m := range assets.MonsterMoves[monster.NationalID].Moves {
+			if m.LearnType != "level up" {
+				continue
+			}
+			name, ok := names[moveKey(m.Name)]
+			if !ok {
+				fmt.Printf("Unknown move %q in learnset of %s\n", m.Name, monster.Name)
+				continue
+			}
+			learnset.LevelUp = append(learnset.LevelUp, learnsetMove{Level: m.Level, Move: name})
+		}
+
+		sort.SliceStable(learnset.LevelUp, func(i, j int) bool {
+			return learnset.LevelUp[i].Level < learnset.LevelUp[j].Level
+		})
+		learnsets = append(learnsets, learnset)
+	}
+
+	return learnsets
+}
+
+// moveKey normalizes "Vine Whip", "vine-whip" and "vine_whip" to one key
+func moveKey(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name)
+}
 
+// loadAssets downloads and decodes every pokedex.org asset listed in pokedex.md
+func loadAssets() (assetSet, error) {
+	assets := assetSet{
+		Supplemental: map[int]supplementalMonster{},
+		Descriptions: map[int]descriptionDoc{},
+		MonsterMoves: map[int]monsterMovesDoc{},
+	}
+
+	for _, name := range skimMonsterAssets {
+		data, err := fetchAsset(name)
+		if err != nil {
+			return assets, err
+		}
+		monsters, err := parseSkimMonsters(name, data)
+		if err != nil {
+			return assets, err
+		}
+		assets.Monsters = append(assets.Monsters, monsters...)
+	}
+
+	for _, name := range supplementalAssets {
+		data, err := fetchAsset(name)
+		if err != nil {
+			return assets, err
+		}
+		monsters, err := parseSupplementalMonsters(name, data)
+		if err != nil {
+			return assets, err
+		}
+		for _, m := range monsters {
+			assets.Supplemental[m.NationalID] = m
+		}
+	}
+
+	for _, name := range descriptionAssets {
+		data, err := fetchAsset(name)
+		if err != nil {
+			return assets, err
+		}
+		descriptions, err := parseDescriptions(name, data)
+		if err != nil {
+			return assets, err
+		}
+		for _, d := range descriptions {
+			assets.Descriptions[d.NationalID] = d
+		}
+	}
+
+	for _, name := range moveAssets {
+		data, err := fetchAsset(name)
+		if err != nil {
+			return assets, err
+		}
+		moves, err := parseMoves(name, data)
+		if err != nil {
+			return assets, err
+		}
+		assets.Moves = append(assets.Moves, moves...)
+	}
+
+	for _, name := range monsterMoveAssets {
+		data, err := fetchAsset(name)
+		if err != nil {
+			return assets, err
+		}
+		learnsets, err := parseMonsterMoves(name, data)
+		if err != nil {
+			return assets, err
+		}
+		for _, l := range learnsets {
+			assets.MonsterMoves[l.NationalID] = l
+		}
+	}
+
+	data, err := fetchAsset(evolutionAsset)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return assets, err
 	}
-	res, err := client.Do(req)
+	if assets.Evolutions, err = parseEvolutions(evolutionAsset, data); err != nil {
+		return assets, err
+	}
+
+	data, err = fetchAsset(typeAsset)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return assets, err
+	}
+	if assets.Types, err = parseTypes(typeAsset, data); err != nil {
+		return assets, err
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	return assets, nil
+}
+
+func fetchAsset(name string) ([]byte, error) {
+	res, err := http.Get(assetBaseURL + name)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, fmt.Errorf("failed to fetch %s: %w", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", name, res.Status)
 	}
 
-	to := strings.Split(string(body), "\"to\"")
-	to = to[1:]
-	for _, v := range to {
-		evolution := evolution{}
-		evolution.To, _ = strconv.Atoi(getSubstringBetween(v, "nationalId\":", ","))
-		evolution.ToLevel, _ = strconv.Atoi(getSubstringBetween(v, "level\":", "}"))
-		pokemonId, _ := strconv.Atoi(getSubstringBetween(v, "_id\":\"", "\""))
-		evolutions[pokemonId] = evolution
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
 	}
+	sources.Record(assetBaseURL+name, body)
+	return body, nil
+}
 
-	from := strings.Split(string(body), "\"from\"")
-	from = from[1:]
-	for _, v := range from {
-		evolution := evolution{}
-		evolution.From, _ = strconv.Atoi(getSubstringBetween(v, "nationalId\":", ","))
-		evolution.FromLevel, _ = strconv.Atoi(getSubstringBetween(v, "level\":", "}"))
-		pokemonId, _ := strconv.Atoi(getSubstringBetween(v, "_id\":\"", "\""))
+// decodeAsset walks the documents of a dump file and decodes each one into T.
+// Every error names the file, the document and what was wrong with it.
+func decodeAsset[T any](source string, data []byte, validate func(id string, doc *T) error) ([]T, error) {
+	var docs []T
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		offset := decoder.InputOffset()
+		var batch assetBatch
+		err := decoder.Decode(&batch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid JSON at byte %d: %w", source, offset, err)
+		}
+		if batch.Docs == nil {
+			// The header and trailing sequence markers carry no documents
+			if batch.Version != "" || batch.DBInfo != nil || batch.Seq != nil {
+				continue
+			}
+			return nil, fmt.Errorf("%s: unexpected object at byte %d", source, offset)
+		}
 
-		if _, ok := evolutions[pokemonId]; ok {
-			evolution.To = evolutions[pokemonId].To
-			evolution.ToLevel = evolutions[pokemonId].ToLevel
-			evolutions[pokemonId] = evolution
-		} else {
-			evolutions[pokemonId] = evolution
+		for i, raw := range batch.Docs {
+			var meta assetDoc
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				return nil, fmt.Errorf("%s: doc %d at byte %d: %w", source, i, offset, err)
+			}
+			if meta.Deleted || strings.HasPrefix(meta.ID, "_design/") {
+				continue
+			}
+
+			var doc T
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				return nil, fmt.Errorf("%s: doc %q: %w", source, meta.ID, err)
+			}
+			if err := validate(meta.ID, &doc); err != nil {
+				return nil, fmt.Errorf("%s: doc %q: %w", source, meta.ID, err)
+			}
+			docs = append(docs, doc)
 		}
 	}
 
-	return evolutions
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%s: no documents found", source)
+	}
+	return docs, nil
+}
+
+// No base stat of any Pokémon is higher
+const maxBaseStat = 255
+
+func parseSkimMonsters(source string, data []byte) ([]skimMonster, error) {
+	return decodeAsset(source, data, func(id string, m *skimMonster) error {
+		if m.Name == "" {
+			return fmt.Errorf("missing name")
+		}
+		if m.NationalID <= 0 {
+			return fmt.Errorf("missing national_id")
+		}
+		if len(m.Types) == 0 {
+			return fmt.Errorf("%s has no types", m.Name)
+		}
+		stats := []struct {
+			name  string
+			value int
+		}{
+			{"hp", m.HP},
+			{"attack", m.Attack},
+			{"defense", m.Defense},
+			{"sp_atk", m.SpecialAttack},
+			{"sp_def", m.SpecialDefense},
+			{"speed", m.Speed},
+		}
+		for _, stat := range stats {
+			if stat.value <= 0 || stat.value > maxBaseStat {
+				return fmt.Errorf("%s has an invalid %s of %d", m.Name, stat.name, stat.value)
+			}
+		}
+		return nil
+	})
+}
+
+func parseSupplementalMonsters(source string, data []byte) ([]supplementalMonster, error) {
+	return decodeAsset(source, data, func(id string, m *supplementalMonster) error {
+		if m.NationalID <= 0 {
+			return fmt.Errorf("missing national_id")
+		}
+		if m.CatchRate < 0 || m.CatchRate > 255 {
+			return fmt.Errorf("#%d has an invalid catch_rate of %d", m.NationalID, m.CatchRate)
+		}
+		return nil
+	})
+}
+
+func parseDescriptions(source string, data []byte) ([]descriptionDoc, error) {
+	return decodeAsset(source, data, func(id string, d *descriptionDoc) error {
+		if d.Description == "" {
+			return fmt.Errorf("missing description")
+		}
+		nationalID, err := nationalIDFromURI(d.Pokemon.ResourceURI)
+		if err != nil {
+			return err
+		}
+		d.NationalID = nationalID
+		return nil
+	})
+}
+
+func parseEvolutions(source string, data []byte) ([]evolutionDoc, error) {
+	return decodeAsset(source, data, func(id string, e *evolutionDoc) error {
+		nationalID, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("_id is not a national id: %w", err)
+		}
+		e.NationalID = nationalID
+		for _, link := range append(append(evolutionLinks{}, e.From...), e.To...) {
+			if link.NationalID <= 0 {
+				return fmt.Errorf("evolution link %q has no nationalId", link.Name)
+			}
+		}
+		return nil
+	})
+}
+
+func parseTypes(source string, data []byte) ([]typeDoc, error) {
+	return decodeAsset(source, data, func(id string, t *typeDoc) error {
+		if t.Name == "" {
+			return fmt.Errorf("missing name")
+		}
+		return nil
+	})
+}
+
+func parseMoves(source string, data []byte) ([]moveDoc, error) {
+	return decodeAsset(source, data, func(id string, m *moveDoc) error {
+		if m.Name == "" {
+			return fmt.Errorf("missing name")
+		}
+		if m.PP < 0 || m.Power < 0 || m.Accuracy < 0 || m.Accuracy > 100 {
+			return fmt.Errorf("move %s has invalid power/accuracy/pp", m.Name)
+		}
+		return nil
+	})
+}
+
+func parseMonsterMoves(source string, data []byte) ([]monsterMovesDoc, error) {
+	return decodeAsset(source, data, func(id string, m *monsterMovesDoc) error {
+		nationalID, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("_id is not a national id: %w", err)
+		}
+		m.NationalID = nationalID
+		for _, move := range m.Moves {
+			if move.Name == "" {
+				return fmt.Errorf("learnset entry without a move name")
+			}
+		}
+		return nil
+	})
+}
+
+// nationalIDFromURI reads the id out of a resource uri like /api/v1/pokemon/25/
+func nationalIDFromURI(uri string) (int, error) {
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+	id, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid resource uri %q", uri)
+	}
+	return id, nil
 }
 
 func getExp() map[int]int {
@@ -265,6 +814,7 @@ func getExp() map[int]int {
 		fmt.Println(err)
 		return nil
 	}
+	sources.Record(url, body)
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(body)))
 	if err != nil {
@@ -286,88 +836,3 @@ func getExp() map[int]int {
 
 	return exps
 }
-
-func getSubstringBetween(str, start, end string) string {
-	startIndex := strings.Index(str, start)
-	if startIndex == -1 {
-		return ""
-	}
-
-	startIndex += len(start)
-	endIndex := strings.Index(str[startIndex:], end)
-	if endIndex == -1 {
-		return ""
-	}
-
-	return str[startIndex : startIndex+endIndex]
-}
-
-func capturePokemon(playerName string, pokemon pokemon) string {
-	// open playerpokemon.json file
-	file, err := ioutil.ReadFile("playerpokemon.json")
-	// if file not found, create a new file
-	if err != nil {
-		file, err := os.Create("playerpokemon.json")
-		if err != nil {
-			return "Error creating file: " + err.Error()
-		}
-		defer file.Close()
-
-		// Write the data to the file
-		_, err = file.Write([]byte("your data here"))
-		if err != nil {
-			return "Error writing to file: " + err.Error()
-		}
-
-		return "File created and data written successfully"
-	}
-
-	// read the file into a struct
-	players := []playerPokemon{}
-	err = json.Unmarshal(file, &players)
-	// if there is an error, return the error
-	if err != nil {
-		return "Error unmarshalling file: " + err.Error()
-	}
-
-	// return if that player already has a pokemon with the same name
-	for _, v := range players {
-		if v.Name == playerName {
-			if v.Pokemon.Name == pokemon.Name {
-				return "You already have this pokemon"
-			}
-		}
-	}
-
-	// recalculate pokemon attribute
-	rand.Seed(time.Now().UnixNano())
-
-	// Generate a random float64 between 0.5 and 1
-	ev := 0.5 + rand.Float64()*0.5
-	pokemon.Exp = int(float64(pokemon.Exp) * ev)
-	pokemon.SpecialDefense = int(float64(pokemon.SpecialDefense) * ev)
-	pokemon.SpecialAttack = int(float64(pokemon.SpecialAttack) * ev)
-	pokemon.Defense = int(float64(pokemon.Defense) * ev)
-	pokemon.Attack = int(float64(pokemon.Attack) * ev)
-	pokemon.HP = int(float64(pokemon.HP) * ev)
-
-	players = append(players, playerPokemon{
-		Name:    playerName,
-		Pokemon: pokemon,
-	})
-
-	// write the updated struct to the file
-	data, err := json.MarshalIndent(players, "", "  ")
-	// if there is an error, return the error
-	if err != nil {
-		return "Error marshalling data: " + err.Error()
-	}
-	// write the updated struct to the file
-	err = ioutil.WriteFile("playerpokemon.json", data, 0644)
-	// if there is an error, return the error
-	if err != nil {
-		return "Error writing to file: " + err.Error()
-
-	}
-	return "Pokemon captured successfully"
-}
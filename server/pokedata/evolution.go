@@ -0,0 +1,129 @@
+package pokedata
+
+import "sort"
+
+// How an evolution is triggered
+const (
+	TriggerLevel      = "level"
+	TriggerItem       = "item"
+	TriggerTrade      = "trade"
+	TriggerFriendship = "friendship"
+	TriggerOther      = "other"
+)
+
+// One edge of the evolution graph, as main.go stores it in pokemon.json
+type Evolution struct {
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+	Trigger string `json:"trigger"`
+	Level   int    `json:"level,omitempty"`
+	Item    string `json:"item,omitempty"`
+}
+
+// The evolution fields of a species in pokemon.json. Older files only have
+// from/to, newer ones also list every evolution.
+type EvolutionEntry struct {
+	ID         int         `json:"national_id"`
+	From       int         `json:"from"`
+	FromLevel  int         `json:"from_level"`
+	To         int         `json:"to"`
+	ToLevel    int         `json:"to_level"`
+	Evolutions []Evolution `json:"evolutions"`
+}
+
+// EvolutionEdges returns every evolution of the entries. Entries without a
+// list fall back to their from/to, which only recorded a level.
+func EvolutionEdges(entries []EvolutionEntry) []Evolution {
+	var edges []Evolution
+	for _, entry := range entries {
+		if len(entry.Evolutions) > 0 {
+			edges = append(edges, entry.Evolutions...)
+			continue
+		}
+		if entry.To != 0 {
+			edges = append(edges, Evolution{From: entry.ID, To: entry.To, Trigger: legacyTrigger(entry.ToLevel), Level: entry.ToLevel})
+		}
+		if entry.From != 0 {
+			edges = append(edges, Evolution{From: entry.From, To: entry.ID, Trigger: legacyTrigger(entry.FromLevel), Level: entry.FromLevel})
+		}
+	}
+	return edges
+}
+
+// Older files only recorded a level, so anything else is unknown
+func legacyTrigger(level int) string {
+	if level > 0 {
+		return TriggerLevel
+	}
+	return TriggerOther
+}
+
+// EvolutionGraph links every species to the species it evolves from and
+// into, so branching families like Eevee keep all of their targets.
+type EvolutionGraph struct {
+	next map[int][]Evolution
+	prev map[int][]Evolution
+}
+
+func NewEvolutionGraph(edges []Evolution) *EvolutionGraph {
+	graph := &EvolutionGraph{next: map[int][]Evolution{}, prev: map[int][]Evolution{}}
+	seen := map[[2]int]bool{}
+
+	for _, e := range edges {
+		if seen[[2]int{e.From, e.To}] {
+			continue
+		}
+		seen[[2]int{e.From, e.To}] = true
+		graph.next[e.From] = append(graph.next[e.From], e)
+		graph.prev[e.To] = append(graph.prev[e.To], e)
+	}
+
+	for id := range graph.next {
+		sort.Slice(graph.next[id], func(i, j int) bool { return graph.next[id][i].To < graph.next[id][j].To })
+	}
+	return graph
+}
+
+// NextEvolutions returns the evolutions directly out of a species
+func (g *EvolutionGraph) NextEvolutions(id int) []Evolution {
+	return g.next[id]
+}
+
+// PreviousEvolution returns the evolution that leads into a species, if any
+func (g *EvolutionGraph) PreviousEvolution(id int) (Evolution, bool) {
+	if len(g.prev[id]) == 0 {
+		return Evolution{}, false
+	}
+	return g.prev[id][0], true
+}
+
+// Chain returns every evolution of the family a species belongs to, starting
+// from its base form and walking the branches breadth first.
+func (g *EvolutionGraph) Chain(id int) []Evolution {
+	root := id
+	visited := map[int]bool{root: true}
+	for {
+		prev, ok := g.PreviousEvolution(root)
+		if !ok || visited[prev.From] {
+			break
+		}
+		root = prev.From
+		visited[root] = true
+	}
+
+	chain := []Evolution{}
+	queue := []int{root}
+	done := map[int]bool{root: true}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range g.next[current] {
+			chain = append(chain, e)
+			if !done[e.To] {
+				done[e.To] = true
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	return chain
+}
@@ -0,0 +1,70 @@
+package pokedata
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	graph := NewEvolutionGraph([]Evolution{
+		{From: 134, To: 133}, // a cycle must not loop forever
+		{From: 133, To: 136, Trigger: TriggerItem, Item: "fire-stone"},
+		{From: 133, To: 134, Trigger: TriggerItem, Item: "water-stone"},
+		{From: 133, To: 134, Trigger: TriggerItem, Item: "water-stone"},
+		{From: 172, To: 25, Trigger: TriggerFriendship},
+		{From: 25, To: 26, Trigger: TriggerItem, Item: "thunder-stone"},
+	})
+	tests := []struct {
+		id   int
+		want [][2]int
+	}{
+		{26, [][2]int{{172, 25}, {25, 26}}},
+		{172, [][2]int{{172, 25}, {25, 26}}},
+		{136, [][2]int{{134, 133}, {133, 134}, {133, 136}}},
+		{1, nil},
+	}
+	for _, test := range tests {
+		var got [][2]int
+		for _, e := range graph.Chain(test.id) {
+			got = append(got, [2]int{e.From, e.To})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Chain(%d) = %v, want %v", test.id, got, test.want)
+		}
+	}
+}
+
+// The committed pokemon.json predates the evolutions list, so its chains
+// come from from/to alone
+func TestChainOfCommittedData(t *testing.T) {
+	content, err := os.ReadFile("../pokemon.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []EvolutionEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		t.Fatal(err)
+	}
+	graph := NewEvolutionGraph(EvolutionEdges(entries))
+
+	want := []Evolution{
+		{From: 1, To: 2, Trigger: TriggerLevel, Level: 16},
+		{From: 2, To: 3, Trigger: TriggerLevel, Level: 32},
+	}
+	if got := graph.Chain(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chain(Ivysaur) = %v, want %v", got, want)
+	}
+
+	// Every branch of Eevee hangs off the base form
+	eevee := graph.NextEvolutions(133)
+	if len(eevee) != 7 {
+		t.Errorf("Eevee evolves into %v, want 7 species", eevee)
+	}
+	for _, e := range eevee {
+		if chain := graph.Chain(e.To); !reflect.DeepEqual(chain, eevee) {
+			t.Errorf("Chain(%d) = %v, want the family of Eevee", e.To, chain)
+		}
+	}
+}
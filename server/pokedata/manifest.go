@@ -0,0 +1,190 @@
+package pokedata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// The manifest describes how the data files were produced. It is written
+// next to them and the server checks the hashes in it before loading them.
+//
+// Every program writes its own data file in its own schema, so each data
+// file gets its own manifest, e.g. pokedex.manifest.json for pokedex.json.
+func ManifestPath(dataFile string) string {
+	return strings.TrimSuffix(dataFile, filepath.Ext(dataFile)) + ".manifest.json"
+}
+
+// Schemas of the data files, one per program that writes them
+const (
+	SchemaCrawler = "crawler-pokedex" // pokedex.json of crawler.go
+	SchemaImport  = "main-pokemon"    // pokemon.json, moves.json and learnsets.json of main.go
+	SchemaPokeAPI = "pokeapi-types"   // the PokéAPI type lists of server.go
+)
+
+type Manifest struct {
+	Version        string    `json:"version"`
+	Schema         string    `json:"schema"`
+	CrawlerVersion string    `json:"crawler_version"`
+	CreatedAt      time.Time `json:"created_at"`
+	Files          []File    `json:"files"`
+	Sources        []Source  `json:"sources"`
+}
+
+type File struct {
+	Name    string `json:"name"`
+	SHA256  string `json:"sha256"`
+	Records int    `json:"records"`
+}
+
+type Source struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	SHA256    string    `json:"sha256"`
+}
+
+// Sources are the pages and documents a crawl downloaded, in order
+type Sources []Source
+
+func (s *Sources) Record(url string, content []byte) {
+	*s = append(*s, Source{URL: url, FetchedAt: time.Now().UTC(), SHA256: SHA256Hex(content)})
+}
+
+func SHA256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Producer names the program that writes a dataset after the code it was
+// built from: the VCS revision when the build has one, the hash of the
+// executable otherwise. A changed program gets a new name without anyone
+// bumping a version by hand.
+func Producer(program string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		revision, modified := "", false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" && !modified {
+			return program + "@" + revision
+		}
+	}
+	if executable, err := os.Executable(); err == nil {
+		if content, err := os.ReadFile(executable); err == nil {
+			return program + "@" + SHA256Hex(content)[:12]
+		}
+	}
+	return program + "@unknown"
+}
+
+// NewManifest describes a dataset of one schema created now. The version is
+// derived from the creation time and the hash of the first file.
+func NewManifest(producer, schema string, files []File, sources []Source) Manifest {
+	manifest := Manifest{
+		Schema:         schema,
+		CrawlerVersion: producer,
+		CreatedAt:      time.Now().UTC(),
+		Files:          files,
+		Sources:        sources,
+	}
+	manifest.setVersion()
+	return manifest
+}
+
+func (m *Manifest) setVersion() {
+	checksum := "empty"
+	if len(m.Files) > 0 {
+		checksum = m.Files[0].SHA256
+	}
+	if len(checksum) > 12 {
+		checksum = checksum[:12]
+	}
+	m.Version = m.CreatedAt.Format("20060102T150405Z") + "-" + checksum
+}
+
+// UpdateFile records the new content of a listed file and derives a new
+// version from it
+func (m *Manifest) UpdateFile(name string, content []byte) {
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			m.Files[i].SHA256 = SHA256Hex(content)
+		}
+	}
+	m.CreatedAt = time.Now().UTC()
+	m.setVersion()
+}
+
+// WriteFile writes a data file and returns its manifest entry
+func WriteFile(name string, content []byte, records int) (File, error) {
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		return File{}, err
+	}
+	return File{Name: name, SHA256: SHA256Hex(content), Records: records}, nil
+}
+
+func (m Manifest) Write(path string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func ReadManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// Verify checks that the manifest at path describes data of the schema,
+// every file listed in it against its hash and that dataFile is one of them.
+// Without a manifest the data is "unversioned", which is an error when the
+// manifest is required.
+func Verify(path, dataFile, schema string, required bool) (Manifest, error) {
+	manifest, err := ReadManifest(path)
+	if os.IsNotExist(err) && !required {
+		return Manifest{Version: "unversioned"}, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if manifest.Schema != schema {
+		return manifest, fmt.Errorf("%s describes %q data, %s needs %q", path, manifest.Schema, dataFile, schema)
+	}
+
+	listed := false
+	for _, file := range manifest.Files {
+		content, err := os.ReadFile(file.Name)
+		if err != nil {
+			return manifest, fmt.Errorf("%s lists %s: %w", path, file.Name, err)
+		}
+		if checksum := SHA256Hex(content); checksum != file.SHA256 {
+			return manifest, fmt.Errorf("%s has sha256 %s, %s expects %s", file.Name, checksum, path, file.SHA256)
+		}
+		listed = listed || filepath.Clean(file.Name) == filepath.Clean(dataFile)
+	}
+	if !listed {
+		return manifest, fmt.Errorf("%s does not list %s", path, dataFile)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,68 @@
+package pokedata
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestPath(t *testing.T) {
+	for dataFile, want := range map[string]string{
+		"pokedex.json":      "pokedex.manifest.json",
+		"data/types.json":   "data/types.manifest.json",
+		"pokemon":           "pokemon.manifest.json",
+		"dir.v2/moves.json": "dir.v2/moves.manifest.json",
+	} {
+		if got := ManifestPath(dataFile); got != want {
+			t.Errorf("ManifestPath(%s) = %s, want %s", dataFile, got, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "types.json")
+	file, err := WriteFile(dataFile, []byte(`[]`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := ManifestPath(dataFile)
+
+	// Without a manifest the data is unversioned unless one is required
+	if manifest, err := Verify(path, dataFile, SchemaPokeAPI, false); err != nil || manifest.Version != "unversioned" {
+		t.Errorf("Verify without a manifest = %v, %v, want unversioned", manifest.Version, err)
+	}
+	if _, err := Verify(path, dataFile, SchemaPokeAPI, true); err == nil {
+		t.Errorf("Verify without a required manifest succeeded")
+	}
+
+	manifest := NewManifest("test", SchemaPokeAPI, []File{file}, nil)
+	if err := manifest.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Verify(path, dataFile, SchemaPokeAPI, true); err != nil || got.Version != manifest.Version {
+		t.Errorf("Verify = %v, %v, want version %s", got.Version, err, manifest.Version)
+	}
+
+	tests := []struct {
+		name     string
+		dataFile string
+		schema   string
+		content  string
+		err      string
+	}{
+		{"schema", dataFile, SchemaCrawler, `[]`, `describes "pokeapi-types" data`},
+		{"not listed", filepath.Join(dir, "pokedex.json"), SchemaPokeAPI, `[]`, "does not list"},
+		{"changed", dataFile, SchemaPokeAPI, `[{}]`, "has sha256"},
+	}
+	for _, test := range tests {
+		if err := os.WriteFile(dataFile, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Verify(path, test.dataFile, test.schema, false)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Verify error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package pokedata
+
+// An entry of pokedex.json, as crawler.go writes it and sprites.go, export.go
+// and game.go read it. Every form of a species is its own entry. Entries of
+// one species share Index and Species; Form is empty for the default form.
+type Pokemon struct {
+	Index       string   `json:"index"`
+	Name        string   `json:"name"`
+	Species     string   `json:"species"`
+	Form        string   `json:"form,omitempty"`
+	Generation  int      `json:"generation"`
+	Exp         int      `json:"exp"`
+	HP          int      `json:"hp"`
+	Attack      int      `json:"attack"`
+	Defense     int      `json:"defense"`
+	SpAttack    int      `json:"sp_attack"`
+	SpDefense   int      `json:"sp_defense"`
+	Speed       int      `json:"speed"`
+	TotalEVs    int      `json:"total_evs"`
+	Type        []string `json:"type"`
+	Description string   `json:"description"`
+	Height      string   `json:"height"`
+	Weight      string   `json:"weight"`
+	ImageURL    string   `json:"image_url"`
+	// Filled in by sprites.go once the image is cached locally
+	ImagePath   string `json:"image_path,omitempty"`
+	ImageSHA256 string `json:"image_sha256,omitempty"`
+}
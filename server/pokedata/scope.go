@@ -0,0 +1,105 @@
+// Package pokedata holds what the programs in server/ share about the
+// dataset: the entries of pokedex.json, which Pokémon a crawl fetches, how
+// species evolve and the manifest that describes how the data files were
+// produced.
+package pokedata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// All 18 types, as pokedex.org and PokéAPI name them
+var AllTypes = []string{
+	"normal", "fire", "water", "grass", "electric", "ice", "fighting", "poison", "ground",
+	"flying", "psychic", "bug", "rock", "ghost", "dragon", "dark", "steel", "fairy",
+}
+
+// Last national dex number of every generation
+var GenerationEnds = []int{151, 251, 386, 493, 649, 721, 809, 905, 1025}
+
+// Generations and types every crawler fetches unless told otherwise
+const (
+	DefaultGenerations = "1-9"
+	DefaultTypes       = "all"
+)
+
+// Generations is an inclusive range of generations
+type Generations struct {
+	From int
+	To   int
+}
+
+// GenerationOf returns the generation a national dex number belongs to, 0
+// when it is past the last one
+func GenerationOf(index int) int {
+	for i, end := range GenerationEnds {
+		if index <= end {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Contains reports whether a national dex number is in the range
+func (g Generations) Contains(index int) bool {
+	return g.Has(GenerationOf(index))
+}
+
+// Has reports whether a generation is in the range
+func (g Generations) Has(generation int) bool {
+	return generation >= g.From && generation <= g.To
+}
+
+// LastIndex is the last national dex number of the range
+func (g Generations) LastIndex() int {
+	return GenerationEnds[g.To-1]
+}
+
+// ParseGenerations reads "3" or "1-5"
+func ParseGenerations(value string) (Generations, error) {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		to = from
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return Generations{}, fmt.Errorf("invalid generation range %q", value)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return Generations{}, fmt.Errorf("invalid generation range %q", value)
+	}
+	if start < 1 || end > len(GenerationEnds) || start > end {
+		return Generations{}, fmt.Errorf("generation range %q must be within 1-%d", value, len(GenerationEnds))
+	}
+	return Generations{From: start, To: end}, nil
+}
+
+// ParseTypes reads a comma separated list of types, "all" or nothing means
+// all 18
+func ParseTypes(value string) ([]string, error) {
+	if value == "" || value == DefaultTypes {
+		return AllTypes, nil
+	}
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !IsType(t) {
+			return nil, fmt.Errorf("unknown type %q", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// IsType reports whether name is one of the 18 types
+func IsType(name string) bool {
+	for _, t := range AllTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package pokegame
+
+import (
+	"math"
+	"math/rand"
+)
+
+// TrainerAI decides for a computer trainer
+type TrainerAI interface {
+	// Choose picks the action of the next turn
+	Choose(b *Battle, side int) Action
+	// Replace picks the team member sent out after the active one fainted
+	Replace(b *Battle, side int) int
+}
+
+// Levels of computer trainers, from the easiest
+var AI_LEVELS = []string{"random", "greedy", "lookahead"}
+
+var AI_TRAINERS = map[string]func(rng *rand.Rand) TrainerAI{
+	"random":    func(rng *rand.Rand) TrainerAI { return randomAI{rng: rng} },
+	"greedy":    func(rng *rand.Rand) TrainerAI { return greedyAI{} },
+	"lookahead": func(rng *rand.Rand) TrainerAI { return lookaheadAI{depth: 2} },
+}
+
+// randomAI attacks with a random move and sends out a random Pokémon
+type randomAI struct {
+	rng *rand.Rand
+}
+
+func (ai randomAI) Choose(b *Battle, side int) Action {
+	attacker := b.Sides[side].Fighter()
+	return Action{Kind: ACTION_ATTACK, Index: ai.rng.Intn(len(attacker.Moves))}
+}
+
+func (ai randomAI) Replace(b *Battle, side int) int {
+	alive := aliveMembers(b.Sides[side])
+	return alive[ai.rng.Intn(len(alive))]
+}
+
+// greedyAI attacks with the move doing the most damage and sends out the
+// Pokémon with the best matchup against the foe
+type greedyAI struct{}
+
+func (greedyAI) Choose(b *Battle, side int) Action {
+	index, _ := bestAttack(b.Sides[side].Fighter(), b.Sides[1-side].Fighter())
+	return Action{Kind: ACTION_ATTACK, Index: index}
+}
+
+func (greedyAI) Replace(b *Battle, side int) int {
+	foe := b.Sides[1-side].Fighter()
+	best, bestScore := -1, 0
+	for _, i := range aliveMembers(b.Sides[side]) {
+		candidate := b.Sides[side].Team[i]
+		_, dealt := bestAttack(candidate, foe)
+		_, taken := bestAttack(foe, candidate)
+		if score := dealt - taken; best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// lookaheadAI tries every action against every answer of the foe on copies
+// of the battle, depth turns ahead, and keeps the action whose worst outcome
+// is the best
+type lookaheadAI struct {
+	depth int
+}
+
+func (ai lookaheadAI) Choose(b *Battle, side int) Action {
+	var best Action
+	bestValue := math.Inf(-1)
+	for _, a := range b.ValidActions(side) {
+		if a.Kind == ACTION_RUN {
+			continue
+		}
+		if value := ai.value(b, side, a, ai.depth); value > bestValue {
+			best, bestValue = a, value
+		}
+	}
+	return best
+}
+
+func (ai lookaheadAI) Replace(b *Battle, side int) int {
+	best, bestValue := -1, math.Inf(-1)
+	for _, i := range aliveMembers(b.Sides[side]) {
+		next := b.clone()
+		next.Sides[side].Active = i
+		value := math.Inf(-1)
+		for _, a := range next.ValidActions(side) {
+			if a.Kind != ACTION_RUN {
+				value = math.Max(value, ai.value(next, side, a, 1))
+			}
+		}
+		if best < 0 || value > bestValue {
+			best, bestValue = i, value
+		}
+	}
+	return best
+}
+
+// value is the worst outcome of an action over the foe's answers
+func (ai lookaheadAI) value(b *Battle, side int, mine Action, depth int) float64 {
+	worst := math.Inf(1)
+	for _, theirs := range b.ValidActions(1 - side) {
+		if theirs.Kind == ACTION_RUN {
+			continue
+		}
+		next := b.clone()
+		var actions [2]Action
+		actions[side], actions[1-side] = mine, theirs
+		next.Play(actions)
+
+		// Both sides replace fainted Pokémon greedily in the simulation
+		for s, t := range next.Sides {
+			if next.Winner() < 0 && t.Fighter().Fainted() {
+				t.Active = greedyAI{}.Replace(next, s)
+			}
+		}
+
+		value := math.Inf(-1)
+		if depth <= 1 || next.Winner() >= 0 {
+			value = next.score(side)
+		} else {
+			for _, a := range next.ValidActions(side) {
+				if a.Kind != ACTION_RUN {
+					value = math.Max(value, ai.value(next, side, a, depth-1))
+				}
+			}
+		}
+		worst = math.Min(worst, value)
+	}
+	return worst
+}
+
+// score rates the battle for a side by the share of HP left on both teams
+func (b *Battle) score(side int) float64 {
+	switch b.Winner() {
+	case side:
+		return 100
+	case 1 - side:
+		return -100
+	}
+	hpShare := func(t *Trainer) float64 {
+		total := 0.0
+		for _, f := range t.Team {
+			total += float64(f.HP) / float64(f.MaxHP)
+		}
+		return total
+	}
+	return hpShare(b.Sides[side]) - hpShare(b.Sides[1-side])
+}
+
+// bestAttack is the move of the attacker doing the most average damage,
+// counting its misses
+func bestAttack(attacker, defender *Fighter) (int, int) {
+	best, bestDamage := 0, -1
+	for i, m := range attacker.Moves {
+		if dealt := int(float64(Damage(attacker, defender, m, AVERAGE_ROLL)) * m.hitChance()); dealt > bestDamage {
+			best, bestDamage = i, dealt
+		}
+	}
+	return best, bestDamage
+}
+
+func aliveMembers(t *Trainer) []int {
+	var alive []int
+	for i, f := range t.Team {
+		if !f.Fainted() {
+			alive = append(alive, i)
+		}
+	}
+	return alive
+}
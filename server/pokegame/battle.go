@@ -0,0 +1,386 @@
+package pokegame
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"pokemonproject/server/pokedata"
+)
+
+// Power of the attacks of Pokémon without a learnset, they attack with
+// their own types
+const MOVE_POWER = 60
+
+// Moves a Pokémon knows, the last ones it learned
+const MAX_MOVES = 4
+
+// The attack of Pokémon that have no other, e.g. species without a type
+var STRUGGLE = Move{Name: "Struggle", Type: "normal", Power: 50}
+
+// Attacks of the attacker's own type do more damage
+const STAB = 1.5
+
+// Damage roll of the simulations, the middle of 0.85 to 1
+const AVERAGE_ROLL = 0.925
+
+// Fighter is a Pokémon in battle, with its stats and moves at its level
+type Fighter struct {
+	Name      string
+	Types     []string
+	Level     int
+	MaxHP     int
+	HP        int
+	Attack    int
+	Defense   int
+	SpAttack  int
+	SpDefense int
+	Speed     int
+	Moves     []Move
+}
+
+func NewFighter(p pokedata.Pokemon, level int, moves Moveset) *Fighter {
+	if level < MIN_LEVEL {
+		level = MIN_LEVEL
+	}
+	if level > MAX_LEVEL {
+		level = MAX_LEVEL
+	}
+	stat := func(base int) int {
+		return base*2*level/100 + 5
+	}
+	types := p.Type
+	if len(types) == 0 {
+		types = []string{"normal"}
+	}
+	f := &Fighter{
+		Name:      p.Name,
+		Types:     types,
+		Level:     level,
+		MaxHP:     p.HP*2*level/100 + level + 10,
+		Attack:    stat(p.Attack),
+		Defense:   stat(p.Defense),
+		SpAttack:  stat(p.SpAttack),
+		SpDefense: stat(p.SpDefense),
+		Speed:     stat(p.Speed),
+		Moves:     moves.Of(p, types, level),
+	}
+	f.HP = f.MaxHP
+	return f
+}
+
+func (f *Fighter) Fainted() bool {
+	return f.HP <= 0
+}
+
+type Trainer struct {
+	Name   string
+	Team   []*Fighter
+	Active int
+	// A wild Pokémon of POKECAT rather than a trainer
+	Wild bool
+}
+
+// Fighter is the active Pokémon of the trainer
+func (t *Trainer) Fighter() *Fighter {
+	return t.Team[t.Active]
+}
+
+func (t *Trainer) Defeated() bool {
+	for _, f := range t.Team {
+		if !f.Fainted() {
+			return false
+		}
+	}
+	return true
+}
+
+// Kinds of action
+const (
+	ACTION_ATTACK = "attack"
+	ACTION_SWITCH = "switch"
+	ACTION_RUN    = "run"
+	// The side does nothing this turn, e.g. it threw a ball or failed to flee
+	ACTION_NONE = "none"
+)
+
+type Action struct {
+	Kind string
+	// Move of the active Pokémon to attack with, or team member to switch to
+	Index int
+}
+
+type Battle struct {
+	Sides [2]*Trainer
+	Turn  int
+	// Side that ran away, -1 for none
+	Forfeit int
+	// nil in simulations, which use the average damage roll and scale the
+	// damage by the chance to hit
+	rng *rand.Rand
+}
+
+func NewBattle(a, b *Trainer, rng *rand.Rand) *Battle {
+	return &Battle{Sides: [2]*Trainer{a, b}, Forfeit: -1, rng: rng}
+}
+
+// clone copies the battle for a simulation
+func (b *Battle) clone() *Battle {
+	c := &Battle{Turn: b.Turn, Forfeit: b.Forfeit}
+	for side, t := range b.Sides {
+		copied := &Trainer{Name: t.Name, Active: t.Active, Wild: t.Wild}
+		for _, f := range t.Team {
+			member := *f
+			copied.Team = append(copied.Team, &member)
+		}
+		c.Sides[side] = copied
+	}
+	return c
+}
+
+// Winner is the side that won, -1 while the battle goes on
+func (b *Battle) Winner() int {
+	if b.Forfeit >= 0 {
+		return 1 - b.Forfeit
+	}
+	for side, t := range b.Sides {
+		if t.Defeated() {
+			return 1 - side
+		}
+	}
+	return -1
+}
+
+// ValidActions lists what a side can do, only switches once its active
+// Pokémon fainted
+func (b *Battle) ValidActions(side int) []Action {
+	t := b.Sides[side]
+	var actions []Action
+	if !t.Fighter().Fainted() {
+		for i := range t.Fighter().Moves {
+			actions = append(actions, Action{Kind: ACTION_ATTACK, Index: i})
+		}
+	}
+	for i, f := range t.Team {
+		if i != t.Active && !f.Fainted() {
+			actions = append(actions, Action{Kind: ACTION_SWITCH, Index: i})
+		}
+	}
+	if !t.Fighter().Fainted() {
+		actions = append(actions, Action{Kind: ACTION_RUN})
+	}
+	return actions
+}
+
+// Play resolves one turn: running away, then switches, then attacks with
+// the faster Pokémon first. It returns what happened.
+func (b *Battle) Play(actions [2]Action) []string {
+	b.Turn++
+	var events []string
+	for side, a := range actions {
+		if a.Kind == ACTION_RUN {
+			b.Forfeit = side
+			return append(events, b.Sides[side].Name+" ran away")
+		}
+	}
+
+	for side, a := range actions {
+		if a.Kind == ACTION_SWITCH {
+			t := b.Sides[side]
+			t.Active = a.Index
+			events = append(events, fmt.Sprintf("%s sends out %s", t.Name, t.Fighter().Name))
+		}
+	}
+
+	order := []int{0, 1}
+	if b.goesFirst(1) {
+		order = []int{1, 0}
+	}
+	for _, side := range order {
+		if actions[side].Kind != ACTION_ATTACK {
+			continue
+		}
+		attacker, defender := b.Sides[side].Fighter(), b.Sides[1-side].Fighter()
+		if attacker.Fainted() || defender.Fainted() {
+			continue
+		}
+		move := attacker.Moves[actions[side].Index]
+		var dealt int
+		if b.rng == nil {
+			dealt = int(float64(Damage(attacker, defender, move, AVERAGE_ROLL)) * move.hitChance())
+		} else if b.rng.Float64() < move.hitChance() {
+			dealt = Damage(attacker, defender, move, 0.85+b.rng.Float64()*0.15)
+		} else {
+			events = append(events, fmt.Sprintf("%s uses %s, but it missed", b.label(side), move.Name))
+			continue
+		}
+		defender.HP -= dealt
+		if defender.HP < 0 {
+			defender.HP = 0
+		}
+
+		event := fmt.Sprintf("%s uses %s: %d damage", b.label(side), move.Name, dealt)
+		switch effect := Effectiveness(move.Type, defender.Types); {
+		case effect == 0:
+			event += ", it doesn't affect " + defender.Name
+		case effect > 1:
+			event += ", it's super effective!"
+		case effect < 1:
+			event += ", it's not very effective"
+		}
+		events = append(events, event)
+		if defender.Fainted() {
+			events = append(events, b.label(1-side)+" fainted")
+		}
+	}
+	return events
+}
+
+// label names the active Pokémon of a side in the battle's events
+func (b *Battle) label(side int) string {
+	t := b.Sides[side]
+	if t.Wild {
+		return "The wild " + t.Fighter().Name
+	}
+	return t.Name + "'s " + t.Fighter().Name
+}
+
+// goesFirst tells if a side attacks first, speed ties are random
+func (b *Battle) goesFirst(side int) bool {
+	mine, theirs := b.Sides[side].Fighter().Speed, b.Sides[1-side].Fighter().Speed
+	if mine != theirs || b.rng == nil {
+		return mine > theirs
+	}
+	return b.rng.Intn(2) == side
+}
+
+// Damage of a move. Physical moves use attack and defense, special moves
+// the special stats, and the type attacks of Pokémon without a learnset the
+// better of the attacker's two.
+func Damage(attacker, defender *Fighter, move Move, roll float64) int {
+	effect := Effectiveness(move.Type, defender.Types)
+	if effect == 0 {
+		return 0
+	}
+	attack, defense := attacker.Attack, defender.Defense
+	if move.Category == "special" || move.Category == "" && attacker.SpAttack > attacker.Attack {
+		attack, defense = attacker.SpAttack, defender.SpDefense
+	}
+	if defense < 1 {
+		defense = 1
+	}
+
+	base := float64(2*attacker.Level/5+2)*float64(move.Power)*float64(attack)/float64(defense)/50 + 2
+	for _, t := range attacker.Types {
+		if t == move.Type {
+			base *= STAB
+			break
+		}
+	}
+	dealt := int(base * effect * roll)
+	if dealt < 1 {
+		dealt = 1
+	}
+	return dealt
+}
+
+// Status shows the battle from one side, with the actions it can take
+func (b *Battle) Status(side int) string {
+	if winner := b.Winner(); winner >= 0 {
+		if winner == side {
+			return "You won the battle\n"
+		}
+		return "You lost the battle\n"
+	}
+
+	mine, theirs := b.Sides[side], b.Sides[1-side]
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Turn %d\n", b.Turn+1)
+	fmt.Fprintf(&sb, "Foe:  %s\n", FighterLine(theirs.Fighter()))
+	fmt.Fprintf(&sb, "You:  %s\n", FighterLine(mine.Fighter()))
+	sb.WriteString(b.ActionsHelp(side))
+	return sb.String()
+}
+
+func FighterLine(f *Fighter) string {
+	return fmt.Sprintf("%s Lv %d [%s] HP %d/%d", f.Name, f.Level, strings.Join(f.Types, "/"), f.HP, f.MaxHP)
+}
+
+func (b *Battle) ActionsHelp(side int) string {
+	t := b.Sides[side]
+	var options []string
+	for _, a := range b.ValidActions(side) {
+		switch a.Kind {
+		case ACTION_ATTACK:
+			m := t.Fighter().Moves[a.Index]
+			options = append(options, fmt.Sprintf("attack %d (%s, %s)", a.Index+1, m.Name, m.Type))
+		case ACTION_SWITCH:
+			f := t.Team[a.Index]
+			options = append(options, fmt.Sprintf("switch %d (%s HP %d/%d)", a.Index+1, f.Name, f.HP, f.MaxHP))
+		case ACTION_RUN:
+			options = append(options, "run")
+		}
+	}
+	if t.Fighter().Fainted() {
+		return "Your Pokemon fainted, choose the next one: " + strings.Join(options, ", ") + "\n"
+	}
+	return "Actions: " + strings.Join(options, ", ") + "\n"
+}
+
+// ParseAction reads "attack N", "switch N" or "run"
+func ParseAction(b *Battle, side int, line string) (Action, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return Action{}, fmt.Errorf("Choose an action")
+	}
+	a := Action{Kind: args[0], Index: 0}
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return Action{}, fmt.Errorf("%q is not a number", args[1])
+		}
+		a.Index = n - 1
+	} else if len(args) > 2 || args[0] == ACTION_SWITCH {
+		return Action{}, fmt.Errorf("Invalid action %q", line)
+	}
+
+	for _, valid := range b.ValidActions(side) {
+		if valid == a {
+			return a, nil
+		}
+	}
+	return Action{}, fmt.Errorf("You can't %s now", line)
+}
+
+// Attacking type -> defending type -> multiplier, 1 when missing
+var TYPE_CHART = map[string]map[string]float64{
+	"normal":   {"rock": 0.5, "ghost": 0, "steel": 0.5},
+	"fire":     {"fire": 0.5, "water": 0.5, "grass": 2, "ice": 2, "bug": 2, "rock": 0.5, "dragon": 0.5, "steel": 2},
+	"water":    {"fire": 2, "water": 0.5, "grass": 0.5, "ground": 2, "rock": 2, "dragon": 0.5},
+	"electric": {"water": 2, "electric": 0.5, "grass": 0.5, "ground": 0, "flying": 2, "dragon": 0.5},
+	"grass":    {"fire": 0.5, "water": 2, "grass": 0.5, "poison": 0.5, "ground": 2, "flying": 0.5, "bug": 0.5, "rock": 2, "dragon": 0.5, "steel": 0.5},
+	"ice":      {"fire": 0.5, "water": 0.5, "grass": 2, "ice": 0.5, "ground": 2, "flying": 2, "dragon": 2, "steel": 0.5},
+	"fighting": {"normal": 2, "ice": 2, "poison": 0.5, "flying": 0.5, "psychic": 0.5, "bug": 0.5, "rock": 2, "ghost": 0, "dark": 2, "steel": 2, "fairy": 0.5},
+	"poison":   {"grass": 2, "poison": 0.5, "ground": 0.5, "rock": 0.5, "ghost": 0.5, "steel": 0, "fairy": 2},
+	"ground":   {"fire": 2, "electric": 2, "grass": 0.5, "poison": 2, "flying": 0, "bug": 0.5, "rock": 2, "steel": 2},
+	"flying":   {"electric": 0.5, "grass": 2, "fighting": 2, "bug": 2, "rock": 0.5, "steel": 0.5},
+	"psychic":  {"fighting": 2, "poison": 2, "psychic": 0.5, "dark": 0, "steel": 0.5},
+	"bug":      {"fire": 0.5, "grass": 2, "fighting": 0.5, "poison": 0.5, "flying": 0.5, "psychic": 2, "ghost": 0.5, "dark": 2, "steel": 0.5, "fairy": 0.5},
+	"rock":     {"fire": 2, "ice": 2, "fighting": 0.5, "ground": 0.5, "flying": 2, "bug": 2, "steel": 0.5},
+	"ghost":    {"normal": 0, "psychic": 2, "ghost": 2, "dark": 0.5},
+	"dragon":   {"dragon": 2, "steel": 0.5, "fairy": 0},
+	"dark":     {"fighting": 0.5, "psychic": 2, "ghost": 2, "dark": 0.5, "fairy": 0.5},
+	"steel":    {"fire": 0.5, "water": 0.5, "electric": 0.5, "ice": 2, "rock": 2, "steel": 0.5, "fairy": 2},
+	"fairy":    {"fire": 0.5, "fighting": 2, "poison": 0.5, "dragon": 2, "dark": 2, "steel": 0.5},
+}
+
+func Effectiveness(moveType string, defender []string) float64 {
+	multiplier := 1.0
+	for _, t := range defender {
+		if m, ok := TYPE_CHART[moveType][t]; ok {
+			multiplier *= m
+		}
+	}
+	return multiplier
+}
@@ -0,0 +1,204 @@
+package pokegame
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"pokemonproject/server/pokedata"
+)
+
+func fighter(types ...string) *Fighter {
+	return &Fighter{Name: "test", Types: types, Level: 50, MaxHP: 100, HP: 100,
+		Attack: 100, Defense: 100, SpAttack: 100, SpDefense: 100, Speed: 100}
+}
+
+func TestDamage(t *testing.T) {
+	ember := Move{Name: "Ember", Type: "fire", Power: 60, Category: "special"}
+	scratch := Move{Name: "Scratch", Type: "normal", Power: 60, Category: "physical"}
+	strong := fighter("normal")
+	strong.SpAttack = 200
+
+	// Level 50, 60 power, equal stats: (22 * 60 / 50 + 2) = 28.4 before
+	// type effectiveness, STAB and the roll
+	tests := []struct {
+		name     string
+		attacker *Fighter
+		defender *Fighter
+		move     Move
+		roll     float64
+		want     int
+	}{
+		{"neutral", fighter("normal"), fighter("normal"), ember, 1, 28},
+		{"super effective", fighter("normal"), fighter("grass"), ember, 1, 56},
+		{"stab", fighter("fire"), fighter("grass"), ember, 1, 85},
+		{"double resisted", fighter("normal"), fighter("fire", "water"), ember, 1, 7},
+		{"immune", fighter("normal"), fighter("ghost"), scratch, 1, 0},
+		{"roll", fighter("normal"), fighter("normal"), ember, 0.85, 24},
+		{"special uses sp attack", strong, fighter("normal"), ember, 1, 54},
+		{"physical uses attack", strong, fighter("normal"), scratch, 1, 42},
+		{"no category uses the better stat", strong, fighter("normal"), Move{Type: "fire", Power: 60}, 1, 54},
+		{"at least 1", fighter("normal"), fighter("rock", "steel"), Move{Type: "normal", Power: 1}, 1, 1},
+	}
+	for _, test := range tests {
+		if got := Damage(test.attacker, test.defender, test.move, test.roll); got != test.want {
+			t.Errorf("%s: Damage = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEffectiveness(t *testing.T) {
+	tests := []struct {
+		move     string
+		defender []string
+		want     float64
+	}{
+		{"water", []string{"fire"}, 2},
+		{"water", []string{"fire", "ground"}, 4},
+		{"grass", []string{"water", "flying"}, 1},
+		{"electric", []string{"ground", "water"}, 0},
+		{"normal", []string{"fairy"}, 1},
+	}
+	for _, test := range tests {
+		if got := Effectiveness(test.move, test.defender); got != test.want {
+			t.Errorf("Effectiveness(%s, %v) = %v, want %v", test.move, test.defender, got, test.want)
+		}
+	}
+}
+
+var testMoveset = NewMoveset([]Move{
+	{Name: "Scratch", Type: "normal", Power: 40, Accuracy: 100, Category: "physical"},
+	{Name: "Ember", Type: "fire", Power: 40, Accuracy: 100, Category: "special"},
+	{Name: "Dragon Rage", Type: "dragon", Power: 40, Accuracy: 100, Category: "special"},
+	{Name: "Fire Fang", Type: "fire", Power: 65, Accuracy: 95, Category: "physical"},
+	{Name: "Slash", Type: "normal", Power: 70, Accuracy: 100, Category: "physical"},
+	{Name: "Growl", Type: "normal", Category: "status"},
+	{Name: "Shadow Punch", Type: "shadow", Power: 60, Accuracy: 100},
+}, []Learnset{{ID: 4, LevelUp: []struct {
+	Level int    `json:"level"`
+	Move  string `json:"move"`
+}{
+	{1, "Scratch"}, {1, "Growl"}, {7, "Ember"}, {10, "Shadow Punch"},
+	{13, "Dragon Rage"}, {17, "Fire Fang"}, {21, "Ember"}, {28, "Slash"},
+}}})
+
+func moveNames(moves []Move) []string {
+	var names []string
+	for _, m := range moves {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestMovesetOf(t *testing.T) {
+	charmander := pokedata.Pokemon{Index: "4", Name: "Charmander", Type: []string{"fire"}}
+	if len(testMoveset.Moves) != 5 {
+		t.Errorf("NewMoveset kept %v, want the 5 attacking moves of known types", testMoveset.Moves)
+	}
+
+	tests := []struct {
+		level int
+		want  []string
+	}{
+		{1, []string{"Scratch"}},
+		{12, []string{"Scratch", "Ember"}},
+		// Relearning Ember moves it last, only the last MAX_MOVES stay
+		{21, []string{"Scratch", "Dragon Rage", "Fire Fang", "Ember"}},
+		{50, []string{"Dragon Rage", "Fire Fang", "Ember", "Slash"}},
+	}
+	for _, test := range tests {
+		if got := moveNames(testMoveset.Of(charmander, charmander.Type, test.level)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Of(level %d) = %v, want %v", test.level, got, test.want)
+		}
+	}
+
+	// Species without a learnset attack with their types
+	pidgey := pokedata.Pokemon{Index: "16", Name: "Pidgey", Type: []string{"normal", "flying"}}
+	got := testMoveset.Of(pidgey, pidgey.Type, 10)
+	want := []Move{{Name: "Normal Attack", Type: "normal", Power: MOVE_POWER}, {Name: "Flying Attack", Type: "flying", Power: MOVE_POWER}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Of(Pidgey) = %v, want %v", got, want)
+	}
+
+	// Species without a type still have a move to attack with
+	for _, types := range [][]string{nil, {""}} {
+		missingno := pokedata.Pokemon{Index: "0", Name: "Missingno", Type: types}
+		if got := testMoveset.Of(missingno, missingno.Type, 10); !reflect.DeepEqual(got, []Move{STRUGGLE}) {
+			t.Errorf("Of(types %q) = %v, want Struggle", types, got)
+		}
+	}
+}
+
+func testBattle() *Battle {
+	mine := fighter("fire")
+	mine.Moves = []Move{{Name: "Scratch", Type: "normal", Power: 40}, {Name: "Ember", Type: "fire", Power: 40}}
+	other := fighter("water")
+	other.Moves = mine.Moves
+	foe := fighter("grass")
+	foe.Moves = mine.Moves
+	return NewBattle(&Trainer{Name: "Ash", Team: []*Fighter{mine, other}}, &Trainer{Name: "AI", Team: []*Fighter{foe}}, nil)
+}
+
+func TestParseAction(t *testing.T) {
+	b := testBattle()
+	valid := map[string]Action{
+		"attack":   {Kind: ACTION_ATTACK, Index: 0},
+		"attack 2": {Kind: ACTION_ATTACK, Index: 1},
+		"switch 2": {Kind: ACTION_SWITCH, Index: 1},
+		"run":      {Kind: ACTION_RUN},
+	}
+	for line, want := range valid {
+		if got, err := ParseAction(b, 0, line); err != nil || got != want {
+			t.Errorf("ParseAction(%q) = %v, %v, want %v", line, got, err, want)
+		}
+	}
+	for _, line := range []string{"", "attack 3", "attack two", "switch", "switch 1", "attack 1 2", "dance"} {
+		if got, err := ParseAction(b, 0, line); err == nil {
+			t.Errorf("ParseAction(%q) = %v, want an error", line, got)
+		}
+	}
+
+	// Once the active Pokémon fainted it can only be switched out
+	b.Sides[0].Fighter().HP = 0
+	if _, err := ParseAction(b, 0, "attack 1"); err == nil {
+		t.Errorf("ParseAction(attack 1) with a fainted Pokémon succeeded")
+	}
+	if _, err := ParseAction(b, 0, "switch 2"); err != nil {
+		t.Errorf("ParseAction(switch 2) with a fainted Pokémon: %v", err)
+	}
+}
+
+func TestGreedyAI(t *testing.T) {
+	b := testBattle()
+	ai := AI_TRAINERS["greedy"](nil)
+	if got := ai.Choose(b, 0); got != (Action{Kind: ACTION_ATTACK, Index: 1}) {
+		t.Errorf("Choose = %v, want Ember against grass", got)
+	}
+	// Against fire the water member hits harder and takes less
+	b.Sides[1].Team[0].Types = []string{"fire"}
+	b.Sides[0].Fighter().HP = 0
+	if got := ai.Replace(b, 0); got != 1 {
+		t.Errorf("Replace = %d, want 1", got)
+	}
+}
+
+func TestRandomAIWithoutTypes(t *testing.T) {
+	missingno := NewFighter(pokedata.Pokemon{Index: "0", Name: "Missingno", Type: []string{""}}, 10, testMoveset)
+	b := NewBattle(&Trainer{Name: "Ash", Team: []*Fighter{fighter("fire")}}, &Trainer{Name: "AI", Team: []*Fighter{missingno}}, nil)
+	ai := AI_TRAINERS["random"](rand.New(rand.NewSource(1)))
+	if got := ai.Choose(b, 1); got != (Action{Kind: ACTION_ATTACK, Index: 0}) {
+		t.Errorf("Choose = %v, want Struggle", got)
+	}
+}
+
+func TestPlayFaintsTheFoe(t *testing.T) {
+	b := testBattle()
+	b.Sides[1].Fighter().HP = 1
+	events := b.Play([2]Action{{Kind: ACTION_ATTACK, Index: 1}, {Kind: ACTION_ATTACK, Index: 0}})
+	if b.Winner() != 0 {
+		t.Errorf("Winner = %d, want 0 after %v", b.Winner(), events)
+	}
+	if b.Sides[0].Fighter().HP != b.Sides[0].Fighter().MaxHP {
+		t.Errorf("the fainted foe still attacked: %v", events)
+	}
+}
@@ -0,0 +1,246 @@
+package pokegame
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tiles of the maps. Paths, doors and the spawn tiles can be walked on.
+const (
+	TILE_PATH  = '.'
+	TILE_GRASS = '"'
+	TILE_WATER = '~'
+	TILE_CAVE  = ':'
+	TILE_DOOR  = 'O'
+	TILE_TREE  = 'T'
+	TILE_ROCK  = '^'
+)
+
+// Spawn region of the tiles where wild Pokémon appear, unless a zone of the
+// map says otherwise
+var TILE_REGIONS = map[byte]string{
+	TILE_GRASS: "grass",
+	TILE_WATER: "water",
+	TILE_CAVE:  "cave",
+}
+
+// Map is one map file of a map directory, named after the file. Maps are
+// YAML, or JSON, which is valid YAML:
+//
+//	title: Route 1
+//	tiles: |
+//	  TTTTTTT
+//	  T.."".O
+//	  TTTTTTT
+//	starts: [[1, 1]]
+//	zones:
+//	  - {region: rare-grass, area: [3, 1, 4, 1]}
+//	warps:
+//	  - {at: [6, 1], to: cave, start: 0}
+//
+// Starts are where players arrive, the first one for new players. A zone
+// makes the spawn tiles of an area, given as x1, y1, x2, y2, use another
+// region of spawns.yaml. A warp takes the player to a start of another map.
+type Map struct {
+	Title  string      `yaml:"title"`
+	Tiles  string      `yaml:"tiles"`
+	Starts [][2]int    `yaml:"starts"`
+	Zones  []SpawnZone `yaml:"zones"`
+	Warps  []Warp      `yaml:"warps"`
+
+	// The file name without its extension
+	ID string `yaml:"-"`
+
+	rows []string
+}
+
+type SpawnZone struct {
+	Region string `yaml:"region"`
+	Area   [4]int `yaml:"area"`
+}
+
+type Warp struct {
+	At    [2]int `yaml:"at"`
+	To    string `yaml:"to"`
+	Start int    `yaml:"start"`
+}
+
+// LoadMaps reads every map of a directory and checks the maps, their links
+// and their spawn regions together. startMap must be one of them.
+func LoadMaps(dir string, startMap string, spawns *SpawnTable) (map[string]*Map, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]*Map)
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		m, err := loadMap(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.ID = strings.TrimSuffix(file.Name(), ext)
+		if loaded[m.ID] != nil {
+			return nil, fmt.Errorf("%s: map %q is defined twice", dir, m.ID)
+		}
+		loaded[m.ID] = m
+	}
+
+	if loaded[startMap] == nil {
+		return nil, fmt.Errorf("%s: start map %q is missing", dir, startMap)
+	}
+	for id, m := range loaded {
+		if err := m.validate(loaded, spawns); err != nil {
+			return nil, fmt.Errorf("%s: map %s: %w", dir, id, err)
+		}
+	}
+	return loaded, nil
+}
+
+func loadMap(path string) (*Map, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var m Map
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.rows = strings.Split(strings.TrimRight(m.Tiles, "\n"), "\n")
+	return &m, nil
+}
+
+func (m *Map) validate(all map[string]*Map, spawns *SpawnTable) error {
+	if m.Tiles == "" {
+		return fmt.Errorf("no tiles")
+	}
+	for y, row := range m.rows {
+		if len(row) != len(m.rows[0]) {
+			return fmt.Errorf("row %d is %d tiles wide, the first one %d", y+1, len(row), len(m.rows[0]))
+		}
+		for x := 0; x < len(row); x++ {
+			if !strings.ContainsRune(`."~:OT^`, rune(row[x])) {
+				return fmt.Errorf("unknown tile %q at %d,%d", row[x], x, y)
+			}
+		}
+	}
+
+	if len(m.Starts) == 0 {
+		return fmt.Errorf("no starts")
+	}
+	for _, start := range m.Starts {
+		if !Walkable(m.Tile(start[0], start[1])) {
+			return fmt.Errorf("start %d,%d is not on a walkable tile", start[0], start[1])
+		}
+	}
+
+	for _, zone := range m.Zones {
+		a := zone.Area
+		if a[0] > a[2] || a[1] > a[3] || m.Tile(a[0], a[1]) == 0 || m.Tile(a[2], a[3]) == 0 {
+			return fmt.Errorf("zone %s: area %v is not within the map", zone.Region, a)
+		}
+		if spawns.Regions[zone.Region] == nil {
+			return fmt.Errorf("zone %s: no such region in the spawn table", zone.Region)
+		}
+	}
+	for y, row := range m.rows {
+		for x := range row {
+			if region, ok := m.Region(x, y); ok && spawns.Regions[region] == nil {
+				return fmt.Errorf("region %s of tile %d,%d is missing from the spawn table", region, x, y)
+			}
+		}
+	}
+
+	for _, w := range m.Warps {
+		target := all[w.To]
+		switch {
+		case !Walkable(m.Tile(w.At[0], w.At[1])):
+			return fmt.Errorf("warp at %d,%d is not on a walkable tile", w.At[0], w.At[1])
+		case target == nil:
+			return fmt.Errorf("warp at %d,%d goes to unknown map %q", w.At[0], w.At[1], w.To)
+		case w.Start < 0 || w.Start >= len(target.Starts):
+			return fmt.Errorf("warp at %d,%d goes to start %d of %s, which has %d", w.At[0], w.At[1], w.Start, w.To, len(target.Starts))
+		}
+	}
+	return nil
+}
+
+// Tile is 0 outside the map
+func (m *Map) Tile(x, y int) byte {
+	if y < 0 || y >= len(m.rows) || x < 0 || x >= len(m.rows[y]) {
+		return 0
+	}
+	return m.rows[y][x]
+}
+
+func Walkable(tile byte) bool {
+	_, spawning := TILE_REGIONS[tile]
+	return spawning || tile == TILE_PATH || tile == TILE_DOOR
+}
+
+// Region is the spawn region of a tile, from the last zone covering it or
+// the tile itself
+func (m *Map) Region(x, y int) (string, bool) {
+	region, ok := TILE_REGIONS[m.Tile(x, y)]
+	if !ok {
+		return "", false
+	}
+	for _, zone := range m.Zones {
+		a := zone.Area
+		if a[0] <= x && x <= a[2] && a[1] <= y && y <= a[3] {
+			region = zone.Region
+		}
+	}
+	return region, true
+}
+
+func (m *Map) WarpAt(x, y int) (Warp, bool) {
+	for _, w := range m.Warps {
+		if w.At == [2]int{x, y} {
+			return w, true
+		}
+	}
+	return Warp{}, false
+}
+
+// An Entity is drawn over the map
+type Entity struct {
+	X, Y   int
+	Symbol byte
+}
+
+// RenderFrame draws a map, the entities over it and lines of text below.
+// Frames start by clearing the screen.
+func RenderFrame(m *Map, entities []Entity, lines ...string) string {
+	var sb strings.Builder
+	sb.WriteString("\033[H\033[2J")
+	sb.WriteString(m.Title + "\n")
+	for y, row := range m.rows {
+		line := []byte(row)
+		for _, e := range entities {
+			if e.Y == y && e.X >= 0 && e.X < len(line) {
+				line[e.X] = e.Symbol
+			}
+		}
+		sb.Write(line)
+		sb.WriteString("\n")
+	}
+	for _, line := range lines {
+		if line != "" {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,122 @@
+package pokegame
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRoute = `title: Route 1
+tiles: |
+  TTTTTTTT
+  T.""..~T
+  T.^O...T
+  TTTTTTTT
+starts:
+  - [1, 1]
+  - [3, 1]
+zones:
+  - {region: water, area: [2, 1, 2, 1]}
+warps:
+  - {at: [3, 2], to: cave, start: 0}
+`
+
+const testCave = `title: Cave
+tiles: |
+  ^^^^^
+  ^:::^
+  ^^O^^
+starts:
+  - [2, 1]
+warps:
+  - {at: [2, 2], to: route1, start: 1}
+`
+
+func TestLoadMaps(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "route1.yaml", testRoute)
+	writeFile(t, dir, "cave.yaml", testCave)
+	writeFile(t, dir, "README.md", "not a map")
+
+	maps, err := LoadMaps(dir, "route1", loadTestSpawns(t))
+	if err != nil {
+		t.Fatalf("LoadMaps: %v", err)
+	}
+	if len(maps) != 2 || maps["route1"].ID != "route1" || maps["cave"].Title != "Cave" {
+		t.Fatalf("LoadMaps = %v, want route1 and cave", maps)
+	}
+
+	route := maps["route1"]
+	tests := []struct {
+		x, y     int
+		tile     byte
+		walkable bool
+		region   string
+	}{
+		{0, 0, TILE_TREE, false, ""},
+		{1, 1, TILE_PATH, true, ""},
+		{2, 1, TILE_GRASS, true, "water"}, // in the zone
+		{3, 1, TILE_GRASS, true, "grass"},
+		{6, 1, TILE_WATER, true, "water"},
+		{2, 2, TILE_ROCK, false, ""},
+		{3, 2, TILE_DOOR, true, ""},
+		{-1, 0, 0, false, ""},
+		{8, 1, 0, false, ""},
+	}
+	for _, test := range tests {
+		tile := route.Tile(test.x, test.y)
+		region, _ := route.Region(test.x, test.y)
+		if tile != test.tile || Walkable(tile) != test.walkable || region != test.region {
+			t.Errorf("%d,%d: tile %q walkable %v region %q, want %q %v %q", test.x, test.y,
+				tile, Walkable(tile), region, test.tile, test.walkable, test.region)
+		}
+	}
+	if w, ok := route.WarpAt(3, 2); !ok || w.To != "cave" || w.Start != 0 {
+		t.Errorf("WarpAt(3, 2) = %v, %v, want start 0 of the cave", w, ok)
+	}
+	if _, ok := route.WarpAt(1, 1); ok {
+		t.Errorf("WarpAt(1, 1) found a warp")
+	}
+}
+
+func TestLoadMapsInvalid(t *testing.T) {
+	tests := []struct {
+		name, route, startMap, err string
+	}{
+		{"start map", testRoute, "pallet", `start map "pallet" is missing`},
+		{"unknown tile", strings.Replace(testRoute, "T.^O", "T.#O", 1), "route1", "unknown tile '#' at 2,2"},
+		{"ragged", strings.Replace(testRoute, "T.^O...T", "T.^O..T", 1), "route1", "row 3 is 7 tiles wide"},
+		{"start on a tree", strings.Replace(testRoute, "[1, 1]", "[0, 1]", 1), "route1", "start 0,1 is not on a walkable tile"},
+		{"zone outside", strings.Replace(testRoute, "[2, 1, 2, 1]", "[2, 1, 9, 1]", 1), "route1", "zone water: area [2 1 9 1] is not within the map"},
+		{"zone region", strings.Replace(testRoute, "region: water", "region: lava", 1), "route1", "zone lava: no such region"},
+		{"warp map", strings.Replace(testRoute, "to: cave", "to: tower", 1), "route1", `goes to unknown map "tower"`},
+		{"warp start", strings.Replace(testRoute, "to: cave, start: 0", "to: cave, start: 1", 1), "route1", "goes to start 1 of cave, which has 1"},
+		{"warp on a rock", strings.Replace(testRoute, "at: [3, 2]", "at: [2, 2]", 1), "route1", "warp at 2,2 is not on a walkable tile"},
+		{"unknown field", testRoute + "music: route1.ogg\n", "route1", "field music not found"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeFile(t, dir, "route1.yaml", test.route)
+		writeFile(t, dir, "cave.yaml", testCave)
+		_, err := LoadMaps(dir, test.startMap, loadTestSpawns(t))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: LoadMaps error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// A map without starts, alone since the cave's warp to it fails too
+	dir := t.TempDir()
+	writeFile(t, dir, "route1.yaml", strings.Replace(testRoute, "  - [1, 1]\n  - [3, 1]\n", "", 1))
+	if _, err := LoadMaps(dir, "route1", loadTestSpawns(t)); err == nil || !strings.Contains(err.Error(), "no starts") {
+		t.Errorf("LoadMaps without starts: %v", err)
+	}
+
+	// Every spawn tile needs its region in the spawn table
+	dir = t.TempDir()
+	writeFile(t, dir, "route1.yaml", testRoute)
+	writeFile(t, dir, "cave.yaml", testCave)
+	spawns := loadTestSpawns(t)
+	delete(spawns.Regions, "cave")
+	if _, err := LoadMaps(dir, "route1", spawns); err == nil || !strings.Contains(err.Error(), "region cave of tile 1,1 is missing") {
+		t.Errorf("LoadMaps without the cave region: %v", err)
+	}
+}
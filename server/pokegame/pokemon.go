@@ -0,0 +1,103 @@
+// Package pokegame holds the rules of server/game.go: the battle engine and
+// its computer trainers, the spawn table of the wild Pokémon and the maps of
+// POKECAT. It reads no flags, game.go passes its settings in.
+package pokegame
+
+import (
+	"strconv"
+	"strings"
+
+	"pokemonproject/server/pokedata"
+)
+
+// Pokémon below this level, like the level 0 entries of old collections,
+// fight at this level
+const MIN_LEVEL = 5
+const MAX_LEVEL = 100
+
+// A move of moves.json, as main.go writes it
+type Move struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Power    int    `json:"power"`
+	Accuracy int    `json:"accuracy"`
+	Category string `json:"category"`
+}
+
+// An entry of learnsets.json
+type Learnset struct {
+	ID      int `json:"national_id"`
+	LevelUp []struct {
+		Level int    `json:"level"`
+		Move  string `json:"move"`
+	} `json:"level_up"`
+}
+
+// Moveset is what Pokémon can attack with. The zero value has no moves and
+// every Pokémon attacks with its own types.
+type Moveset struct {
+	// Attacking moves by name
+	Moves map[string]Move
+	// Learnsets by national dex number
+	Learnsets map[int]Learnset
+}
+
+// NewMoveset keeps the moves that do damage with a known type
+func NewMoveset(moves []Move, learnsets []Learnset) Moveset {
+	set := Moveset{Moves: map[string]Move{}, Learnsets: map[int]Learnset{}}
+	for _, m := range moves {
+		if m.Power > 0 && m.Accuracy >= 0 && m.Accuracy <= 100 && pokedata.IsType(m.Type) {
+			set.Moves[m.Name] = m
+		}
+	}
+	for _, learnset := range learnsets {
+		set.Learnsets[learnset.ID] = learnset
+	}
+	return set
+}
+
+// Of is the last MAX_MOVES attacking moves a species learned by its level,
+// or an attack of each of its types without any. Species without a type
+// still have STRUGGLE.
+func (s Moveset) Of(p pokedata.Pokemon, types []string, level int) []Move {
+	index, _ := strconv.Atoi(p.Index)
+	var moves []Move
+	for _, learned := range s.Learnsets[index].LevelUp {
+		m, ok := s.Moves[learned.Move]
+		if !ok || learned.Level > level {
+			continue
+		}
+		// A move learned again moves to the end
+		for i, known := range moves {
+			if known.Name == m.Name {
+				moves = append(moves[:i], moves[i+1:]...)
+				break
+			}
+		}
+		moves = append(moves, m)
+	}
+	if len(moves) > MAX_MOVES {
+		moves = moves[len(moves)-MAX_MOVES:]
+	}
+
+	if len(moves) == 0 {
+		for _, t := range types {
+			if t == "" {
+				continue
+			}
+			moves = append(moves, Move{Name: strings.ToUpper(t[:1]) + t[1:] + " Attack", Type: t, Power: MOVE_POWER})
+		}
+	}
+	if len(moves) == 0 {
+		moves = append(moves, STRUGGLE)
+	}
+	return moves
+}
+
+// hitChance of a move, moves without an accuracy never miss
+func (m Move) hitChance() float64 {
+	if m.Accuracy <= 0 {
+		return 1
+	}
+	return float64(m.Accuracy) / 100
+}
@@ -0,0 +1,227 @@
+package pokegame
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"pokemonproject/server/pokedata"
+)
+
+// Periods of the day, spawn regions can change their weights in each
+var PERIODS = []string{"morning", "day", "night"}
+
+// SpawnTable is spawns.yaml: the wild Pokémon of every region
+type SpawnTable struct {
+	Regions map[string]*SpawnRegion `yaml:"regions"`
+
+	// Chance of an encounter in regions without a rate of their own
+	encounterRate float64
+}
+
+// SpawnRegion weights species by type and rarity. A species weighs as much
+// as its heaviest type times 100 / its base exp, so species worth more exp
+// are rarer. Species listed by name are added with their weight as is.
+type SpawnRegion struct {
+	// Chance of an encounter on every step, missing for the table's default
+	// and 0 for none
+	Rate *float64 `yaml:"rate"`
+	// Lowest and highest level of the wild Pokémon
+	Levels [2]int `yaml:"levels"`
+	// Lowest and highest base exp of the species picked by type, 0 for no
+	// limit
+	Exp     [2]int             `yaml:"exp"`
+	Types   map[string]float64 `yaml:"types"`
+	Species map[string]float64 `yaml:"species"`
+	// Period of the day -> type -> multiplier of the type's weight. Types
+	// without a weight of their own count as 1, so a period can bring in
+	// new types.
+	Time map[string]map[string]float64 `yaml:"time"`
+
+	// Candidates of every period, filled in by LoadSpawnTable
+	entries map[string][]spawnEntry
+}
+
+type spawnEntry struct {
+	Species pokedata.Pokemon
+	Weight  float64
+}
+
+// LoadSpawnTable reads a spawn table and weighs the species of the pokedex
+// for every region and period. encounterRate is the chance of an encounter
+// in regions without a rate.
+func LoadSpawnTable(path string, pokedex []pokedata.Pokemon, encounterRate float64) (*SpawnTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table := SpawnTable{encounterRate: encounterRate}
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&table); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := table.validate(pokedex); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, region := range table.Regions {
+		region.entries = make(map[string][]spawnEntry)
+		for _, period := range PERIODS {
+			region.entries[period] = region.candidates(pokedex, period)
+		}
+	}
+	return &table, nil
+}
+
+func (t *SpawnTable) validate(pokedex []pokedata.Pokemon) error {
+	byName := make(map[string]bool)
+	for _, p := range pokedex {
+		byName[p.Name] = true
+	}
+	for name, region := range t.Regions {
+		switch {
+		case region.Rate != nil && (*region.Rate < 0 || *region.Rate > 1):
+			return fmt.Errorf("%s: rate must be from 0 to 1", name)
+		case region.Levels[0] < 1 || region.Levels[1] > MAX_LEVEL || region.Levels[0] > region.Levels[1]:
+			return fmt.Errorf("%s: levels must be a range within 1 to %d", name, MAX_LEVEL)
+		case region.Exp[0] < 0 || region.Exp[1] < 0 || (region.Exp[1] > 0 && region.Exp[0] > region.Exp[1]):
+			return fmt.Errorf("%s: exp must be a range, 0 for no limit", name)
+		case len(region.Types) == 0 && len(region.Species) == 0:
+			return fmt.Errorf("%s: needs types or species", name)
+		}
+		for typeName, weight := range region.Types {
+			if !pokedata.IsType(typeName) || weight < 0 {
+				return fmt.Errorf("%s: invalid type weight %s: %v", name, typeName, weight)
+			}
+		}
+		for species, weight := range region.Species {
+			if !byName[species] || weight < 0 {
+				return fmt.Errorf("%s: invalid species weight %s: %v", name, species, weight)
+			}
+		}
+		for period, modifiers := range region.Time {
+			if !isPeriod(period) {
+				return fmt.Errorf("%s: unknown period %q, use %s", name, period, strings.Join(PERIODS, ", "))
+			}
+			for typeName, multiplier := range modifiers {
+				if !pokedata.IsType(typeName) || multiplier < 0 {
+					return fmt.Errorf("%s: %s: invalid multiplier %s: %v", name, period, typeName, multiplier)
+				}
+			}
+		}
+
+		spawnsAny := false
+		for _, period := range PERIODS {
+			spawnsAny = spawnsAny || len(region.candidates(pokedex, period)) > 0
+		}
+		if !spawnsAny {
+			return fmt.Errorf("%s: no species of the pokedex can spawn", name)
+		}
+	}
+	return nil
+}
+
+func isPeriod(name string) bool {
+	for _, period := range PERIODS {
+		if period == name {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates weighs every species of the pokedex for a period
+func (r *SpawnRegion) candidates(pokedex []pokedata.Pokemon, period string) []spawnEntry {
+	var entries []spawnEntry
+	for _, p := range pokedex {
+		weight := r.Species[p.Name]
+		if r.Exp[0] <= p.Exp && (r.Exp[1] == 0 || p.Exp <= r.Exp[1]) {
+			exp := math.Max(float64(p.Exp), 1)
+			weight += r.typeWeight(p.Type, period) * 100 / exp
+		}
+		if weight > 0 {
+			entries = append(entries, spawnEntry{Species: p, Weight: weight})
+		}
+	}
+	return entries
+}
+
+func (r *SpawnRegion) typeWeight(types []string, period string) float64 {
+	best := 0.0
+	for _, t := range types {
+		weight, ok := r.Types[t]
+		if multiplier, modified := r.Time[period][t]; modified {
+			if !ok {
+				weight = 1
+			}
+			weight *= multiplier
+		}
+		best = math.Max(best, weight)
+	}
+	return best
+}
+
+// Rate is the chance of an encounter on every step in a region
+func (t *SpawnTable) Rate(region string) float64 {
+	r := t.Regions[region]
+	if r == nil {
+		return 0
+	}
+	if r.Rate == nil {
+		return t.encounterRate
+	}
+	return *r.Rate
+}
+
+// Spawn rolls for an encounter in a region and picks the wild Pokémon. The
+// same rng and hour always give the same spawns.
+func (t *SpawnTable) Spawn(region string, rng *rand.Rand, hour int) (pokedata.Pokemon, int, bool) {
+	if rng.Float64() >= t.Rate(region) {
+		return pokedata.Pokemon{}, 0, false
+	}
+	return t.Pick(region, rng, hour)
+}
+
+// Pick chooses the wild Pokémon of an encounter and its level
+func (t *SpawnTable) Pick(region string, rng *rand.Rand, hour int) (pokedata.Pokemon, int, bool) {
+	r := t.Regions[region]
+	if r == nil {
+		return pokedata.Pokemon{}, 0, false
+	}
+	entries := r.entries[PeriodOf(hour)]
+	total := 0.0
+	for _, e := range entries {
+		total += e.Weight
+	}
+	if total == 0 {
+		return pokedata.Pokemon{}, 0, false
+	}
+	roll := rng.Float64() * total
+	picked := entries[len(entries)-1].Species
+	for _, e := range entries {
+		if roll < e.Weight {
+			picked = e.Species
+			break
+		}
+		roll -= e.Weight
+	}
+	level := r.Levels[0] + rng.Intn(r.Levels[1]-r.Levels[0]+1)
+	return picked, level, true
+}
+
+// PeriodOf is the period of the day of an hour
+func PeriodOf(hour int) string {
+	switch {
+	case hour >= 6 && hour < 12:
+		return "morning"
+	case hour >= 12 && hour < 18:
+		return "day"
+	}
+	return "night"
+}
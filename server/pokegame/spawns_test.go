@@ -0,0 +1,181 @@
+package pokegame
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pokemonproject/server/pokedata"
+)
+
+var testPokedex = []pokedata.Pokemon{
+	{Index: "1", Name: "Bulbasaur", Exp: 64, Type: []string{"grass", "poison"}},
+	{Index: "7", Name: "Squirtle", Exp: 63, Type: []string{"water"}},
+	{Index: "16", Name: "Pidgey", Exp: 50, Type: []string{"normal", "flying"}},
+	{Index: "25", Name: "Pikachu", Exp: 112, Type: []string{"electric"}},
+	{Index: "92", Name: "Gastly", Exp: 62, Type: []string{"ghost", "poison"}},
+	{Index: "150", Name: "Mewtwo", Exp: 340, Type: []string{"psychic"}},
+}
+
+const testSpawns = `
+regions:
+  grass:
+    levels: [2, 8]
+    exp: [0, 180]
+    types:
+      grass: 3
+      normal: 3
+      psychic: 5
+    species:
+      Pikachu: 0.2
+    time:
+      night:
+        ghost: 2
+  water:
+    rate: 0.5
+    levels: [5, 15]
+    types:
+      water: 1
+  cave:
+    rate: 0
+    levels: [10, 20]
+    types:
+      grass: 1
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestSpawns(t *testing.T) *SpawnTable {
+	t.Helper()
+	table, err := LoadSpawnTable(writeFile(t, t.TempDir(), "spawns.yaml", testSpawns), testPokedex, 0.15)
+	if err != nil {
+		t.Fatalf("LoadSpawnTable: %v", err)
+	}
+	return table
+}
+
+func spawnNames(table *SpawnTable, region string, seed int64, hour, steps int) []string {
+	rng := rand.New(rand.NewSource(seed))
+	var names []string
+	for i := 0; i < steps; i++ {
+		if p, level, ok := table.Spawn(region, rng, hour); ok {
+			names = append(names, fmt.Sprintf("%s Lv %d", p.Name, level))
+		}
+	}
+	return names
+}
+
+func TestSpawnSeeded(t *testing.T) {
+	table := loadTestSpawns(t)
+	first := spawnNames(table, "grass", 42, 14, 200)
+	if len(first) == 0 {
+		t.Fatal("no spawns in 200 steps")
+	}
+	if again := spawnNames(table, "grass", 42, 14, 200); !reflect.DeepEqual(first, again) {
+		t.Errorf("the same seed and hour gave\n%v\nthen\n%v", first, again)
+	}
+	if other := spawnNames(table, "grass", 43, 14, 200); reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 42 and 43 gave the same spawns")
+	}
+}
+
+func TestSpawnRates(t *testing.T) {
+	table := loadTestSpawns(t)
+	tests := []struct {
+		region string
+		want   float64
+	}{
+		{"grass", 0.15}, // no rate, the table's default
+		{"water", 0.5},
+		{"cave", 0},
+		{"sea", 0}, // unknown region
+	}
+	for _, test := range tests {
+		if got := table.Rate(test.region); got != test.want {
+			t.Errorf("Rate(%s) = %v, want %v", test.region, got, test.want)
+		}
+	}
+	if got := spawnNames(table, "cave", 1, 14, 1000); len(got) != 0 {
+		t.Errorf("cave has rate 0 but spawned %v", got)
+	}
+}
+
+func TestSpawnPick(t *testing.T) {
+	table := loadTestSpawns(t)
+	rng := rand.New(rand.NewSource(1))
+	seen := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		p, level, ok := table.Pick("grass", rng, 14)
+		if !ok {
+			t.Fatal("Pick(grass) found nothing")
+		}
+		if level < 2 || level > 8 {
+			t.Errorf("Pick(grass) level %d, want 2 to 8", level)
+		}
+		seen[p.Name]++
+	}
+	// Mewtwo is over the exp limit, Gastly only comes out at night
+	for _, name := range []string{"Bulbasaur", "Pidgey", "Pikachu"} {
+		if seen[name] == 0 {
+			t.Errorf("%s never spawned in the grass: %v", name, seen)
+		}
+	}
+	for _, name := range []string{"Mewtwo", "Gastly", "Squirtle"} {
+		if seen[name] > 0 {
+			t.Errorf("%s spawned in the grass by day: %v", name, seen)
+		}
+	}
+
+	night := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		p, _, _ := table.Pick("grass", rng, 22)
+		night[p.Name]++
+	}
+	if night["Gastly"] == 0 {
+		t.Errorf("Gastly never spawned in the grass at night: %v", night)
+	}
+}
+
+func TestPeriodOf(t *testing.T) {
+	for hour, want := range map[int]string{0: "night", 5: "night", 6: "morning", 11: "morning", 12: "day", 17: "day", 18: "night", 23: "night"} {
+		if got := PeriodOf(hour); got != want {
+			t.Errorf("PeriodOf(%d) = %s, want %s", hour, got, want)
+		}
+	}
+}
+
+func TestLoadSpawnTableInvalid(t *testing.T) {
+	region := "regions:\n  grass:\n"
+	tests := []struct {
+		name, table, err string
+	}{
+		{"rate", region + "    rate: 2\n    levels: [1, 5]\n    types: {grass: 1}\n", "rate must be from 0 to 1"},
+		{"levels", region + "    levels: [5, 1]\n    types: {grass: 1}\n", "levels must be a range"},
+		{"exp", region + "    levels: [1, 5]\n    exp: [100, 50]\n    types: {grass: 1}\n", "exp must be a range"},
+		{"empty", region + "    levels: [1, 5]\n", "needs types or species"},
+		{"type", region + "    levels: [1, 5]\n    types: {grss: 1}\n", "invalid type weight grss"},
+		{"species", region + "    levels: [1, 5]\n    species: {Missingno: 1}\n", "invalid species weight Missingno"},
+		{"period", region + "    levels: [1, 5]\n    types: {grass: 1}\n    time: {evening: {grass: 2}}\n", `unknown period "evening"`},
+		{"nothing spawns", region + "    levels: [1, 5]\n    exp: [1000, 2000]\n    types: {grass: 1}\n", "no species of the pokedex can spawn"},
+		{"unknown field", region + "    levels: [1, 5]\n    types: {grass: 1}\n    weight: 3\n", "field weight not found"},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := writeFile(t, dir, test.name+".yaml", test.table)
+		_, err := LoadSpawnTable(path, testPokedex, 0.15)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: LoadSpawnTable error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package pokegame
+
+import "math"
+
+// NextAlive is the next team member after the active one that can fight,
+// -1 for none
+func NextAlive(t *Trainer) int {
+	for i := 1; i < len(t.Team); i++ {
+		next := (t.Active + i) % len(t.Team)
+		if !t.Team[next].Fainted() {
+			return next
+		}
+	}
+	return -1
+}
+
+// CatchChance grows as the wild Pokémon's HP drops. Species with more base
+// exp are rarer and harder to catch.
+func CatchChance(wild *Fighter, exp int) float64 {
+	rate := 255 * 50 / math.Max(float64(exp), 50)
+	a := float64(3*wild.MaxHP-2*wild.HP) * rate / float64(3*wild.MaxHP)
+	return math.Min(a/255, 1)
+}
+
+// FleeChance always lets faster Pokémon get away, slower ones get better
+// odds with every attempt
+func FleeChance(mine, wild *Fighter, attempts int) float64 {
+	if mine.Speed >= wild.Speed {
+		return 1
+	}
+	return math.Min(float64(mine.Speed*128/wild.Speed+30*attempts)/256, 1)
+}
@@ -0,0 +1,56 @@
+package pokegame
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNextAlive(t *testing.T) {
+	team := &Trainer{Team: []*Fighter{fighter("fire"), fighter("water"), fighter("grass")}, Active: 1}
+	if got := NextAlive(team); got != 2 {
+		t.Errorf("NextAlive = %d, want 2", got)
+	}
+	// Fainted members are skipped and the search wraps around
+	team.Team[2].HP = 0
+	if got := NextAlive(team); got != 0 {
+		t.Errorf("NextAlive = %d, want 0", got)
+	}
+	// The active one does not count
+	team.Team[0].HP = 0
+	if got := NextAlive(team); got != -1 {
+		t.Errorf("NextAlive = %d, want -1", got)
+	}
+}
+
+func TestCatchChance(t *testing.T) {
+	wild := &Fighter{MaxHP: 30, HP: 30}
+	tests := []struct {
+		hp, exp int
+		want    float64
+	}{
+		{30, 50, 1.0 / 3},
+		{1, 50, 88.0 / 90},
+		{30, 10, 1.0 / 3}, // base exp below 50 counts as 50
+		{30, 200, 1.0 / 12},
+	}
+	for _, test := range tests {
+		wild.HP = test.hp
+		if got := CatchChance(wild, test.exp); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("CatchChance(HP %d, exp %d) = %v, want %v", test.hp, test.exp, got, test.want)
+		}
+	}
+}
+
+func TestFleeChance(t *testing.T) {
+	slow, fast := &Fighter{Speed: 50}, &Fighter{Speed: 100}
+	if got := FleeChance(fast, slow, 1); got != 1 {
+		t.Errorf("FleeChance of the faster side = %v, want 1", got)
+	}
+	first, second := FleeChance(slow, fast, 1), FleeChance(slow, fast, 2)
+	if first != 94.0/256 || second != 124.0/256 {
+		t.Errorf("FleeChance of the slower side = %v then %v, want %v then %v", first, second, 94.0/256, 124.0/256)
+	}
+	if got := FleeChance(slow, fast, 10); got != 1 {
+		t.Errorf("FleeChance after 10 attempts = %v, want 1", got)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	crand "crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,149 +15,1627 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/websocket"
+
+	"pokemonproject/pokeconfig"
+	"pokemonproject/server/pokedata"
+)
+
+var pokedex []Pokemon
+
+var LIMIT_DATA int = 10
+
+// Types fetched from PokéAPI when the data file is missing
+var CRAWL_TYPES = pokedata.AllTypes
+
+// Generations fetched from PokéAPI
+var CRAWL_GENERATIONS = pokedata.Generations{From: 1, To: len(pokedata.GenerationEnds)}
+
+// The manifest of DATA_FILE, next to it unless set
+var MANIFEST_FILE = ""
+
+// Data without a manifest only gets a warning unless this is set
+var REQUIRE_MANIFEST = false
+
+// The dataset the server loaded at startup
+var dataset pokedata.Manifest
+
+// Sources fetched by fetchingData, written to its manifest
+var fetchedSources pokedata.Sources
+
+// Address of the TCP game server
+var TCP_ADDR = ":8080"
+
+// Address of the HTTP server that runs next to the TCP game server
+var HTTP_ADDR = ":8081"
+
+// Hosts whose pages may open a WebSocket, besides the server's own host.
+// Browsers send the page's Origin, other clients usually send none.
+var ALLOWED_ORIGINS []string
+
+// Pokedex data file: the type lists fetched from PokéAPI. It is not the
+// pokedex.json of crawler.go or the pokemon.json of main.go.
+var DATA_FILE = "types.json"
+
+// Where accounts and players are stored, only "file" exists for now
+var STORAGE = "file"
+
+// Team rules sent to clients and checked when a team is saved
+var TEAM_SIZE = 3
+var TEAM_SAME_TYPE = true
+
+// Random Pokemon offered per type, 0 offers all of them
+var OFFER_SIZE = 0
+
+// Directory filled by sprites.go
+var SPRITE_DIR = "sprites"
+
+// User is one connection in the registry, ID is the connection's session ID
+type User struct {
+	conn         ClientConn
+	ID           string    `json:"-"`
+	ACCOUNT_ID   string    `json:"-"`
+	NAME         string    `json:"name"`
+	STATE        string    `json:"state"`
+	CONNECTED_AT time.Time `json:"connected_at"`
+	LAST_ACTIVE  time.Time `json:"last_active"`
+	warned       bool
+
+	// Kept while the user is disconnected, so it can resume
+	offer          []Pokemon
+	team           *PokemonOfUser
+	heldState      string
+	disconnectedAt time.Time
+	// Quit, evicted or shut down, nothing to keep
+	drop bool
+}
+
+// States of a connected user
+const (
+	STATE_LOBBY  = "lobby"  // choosing Pokémon
+	STATE_BATTLE = "battle" // in a battle
+	STATE_IDLE   = "idle"   // connected, nothing in progress
+
+	STATE_DISCONNECTED = "disconnected" // waiting for a reconnect
 )
 
-var pokedex []Pokemon
+// How long the state of a dropped connection is kept for a reconnect
+var RECONNECT_GRACE = 2 * time.Minute
+
+// Connected users by session ID
+var users = make(map[string]*User)
+var mu sync.Mutex
+
+type Pokemon struct {
+	ID               int              `json:"id"`
+	Name             string           `json:"name"`
+	DAMAGE_RELATIONS DamageRelation   `json:"damage_relations"`
+	POKEMON          []PokemonItem    `json:"pokemon"`
+}
+
+type DamageRelation struct {
+	DOUBLE_DAMAGE_FROM []DamageRelationItem `json:"double_damage_from"`
+	DOUBLE_DAMAGE_TO   []DamageRelationItem `json:"double_damage_to"`
+	HALF_DAMAGE_FROM   []DamageRelationItem `json:"half_damage_from"`
+	HALF_DAMAGE_TO     []DamageRelationItem `json:"half_damage_to"`
+	NO_DAMAGE_FROM     []DamageRelationItem `json:"no_damage_from"`
+	NO_DAMAGE_TO       []DamageRelationItem `json:"no_damage_to"`
+}
+
+type DamageRelationItem struct {
+	NAME string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type PokemonItem struct {
+	POKEMON_DETAIL PokemonDetail `json:"pokemon"`
+}
+
+type PokemonDetail struct {
+	NAME  string         `json:"name"`
+	URL   string         `json:"url"`
+	STATS []PokemonStats `json:"stats"`
+    TYPES []PokemonType `json:"types"`
+	// Regional forms and megas share a species with their default form
+	ID         int         `json:"id,omitempty"`
+	IS_DEFAULT bool        `json:"is_default,omitempty"`
+	SPECIES    SpeciesItem `json:"species"`
+	FORM       string      `json:"form,omitempty"`
+	GENERATION int         `json:"generation,omitempty"`
+	// Sprite cached in SPRITE_DIR by fetchingData, served under /sprites/
+	SPRITES      *PokemonSprites `json:"sprites,omitempty"`
+	IMAGE_PATH   string          `json:"image_path,omitempty"`
+	IMAGE_SHA256 string          `json:"image_sha256,omitempty"`
+}
+
+// Only read from PokéAPI, the data file keeps the cached copy
+type PokemonSprites struct {
+	FRONT_DEFAULT string `json:"front_default"`
+}
+
+type SpeciesItem struct {
+	NAME string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type PokemonStats struct {
+	BaseStat int `json:"base_stat"`
+	Effort   int `json:"effort"`
+	Stat     struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"stat"`
+}
+
+type PokemonType struct {
+    TYPE struct {
+        Name string `json:"name"`
+		URL  string `json:"url"`
+    } `json:"type"`
+}
+
+// User struct to store user information and their selected Pokémon
+type PokemonOfUser struct {
+	Name     string
+	TypeOfPokemon       string
+	Selected []PokemonDetail // Map to store selected Pokémon by type
+	OwnerID  string          `json:",omitempty"` // Account that owns these Pokémon
+}
+
+type Lobby struct {
+	ID int
+	PLAYERS []PokemonOfUser
+}
+
+var lobby Lobby
+
+func main() {
+	configFile := flag.String("config", "server.yaml", "YAML config file, may be missing unless set explicitly")
+	flag.StringVar(&TCP_ADDR, "tcp-addr", TCP_ADDR, "address of the TCP game server")
+	flag.StringVar(&HTTP_ADDR, "http-addr", HTTP_ADDR, "address of the HTTP and WebSocket server")
+	flag.StringVar(&DATA_FILE, "data", DATA_FILE, "Pokedex data file, fetched from PokéAPI when missing")
+	flag.StringVar(&MANIFEST_FILE, "manifest", MANIFEST_FILE, "dataset manifest checked at startup, next to the data file when empty")
+	flag.BoolVar(&REQUIRE_MANIFEST, "require-manifest", REQUIRE_MANIFEST, "refuse data without a manifest instead of warning")
+	flag.StringVar(&SPRITE_DIR, "sprites", SPRITE_DIR, "directory of cached sprites")
+	flag.StringVar(&STORAGE, "storage", STORAGE, "storage backend for accounts and players, only \"file\" for now")
+	flag.StringVar(&ACCOUNTS_FILE, "accounts", ACCOUNTS_FILE, "account file of the file storage")
+	flag.StringVar(&PLAYER_DIR, "players", PLAYER_DIR, "player directory of the file storage")
+	crawlTypes := flag.String("types", pokedata.DefaultTypes, "comma separated types to fetch when the data file is missing")
+	crawlGenerations := flag.String("gen", pokedata.DefaultGenerations, "generation range to fetch when the data file is missing")
+	flag.IntVar(&LIMIT_DATA, "limit", LIMIT_DATA, "maximum Pokemon per type to fetch, 0 for no limit")
+	flag.IntVar(&TEAM_SIZE, "team-size", TEAM_SIZE, "number of Pokemon in a team")
+	flag.BoolVar(&TEAM_SAME_TYPE, "team-same-type", TEAM_SAME_TYPE, "every Pokemon in a team must have the chosen type")
+	flag.IntVar(&OFFER_SIZE, "offer-size", OFFER_SIZE, "random Pokemon offered per type, 0 to offer all")
+	flag.DurationVar(&SESSION_TTL, "session-ttl", SESSION_TTL, "how long a session token can be resumed")
+	flag.DurationVar(&SHUTDOWN_GRACE, "shutdown-grace", SHUTDOWN_GRACE, "time clients get to finish on shutdown")
+	flag.DurationVar(&RECONNECT_GRACE, "reconnect-grace", RECONNECT_GRACE, "how long a dropped client can resume its session")
+	flag.DurationVar(&READ_TIMEOUT, "read-timeout", READ_TIMEOUT, "drop clients that send nothing for this long, 0 to disable")
+	flag.DurationVar(&WRITE_TIMEOUT, "write-timeout", WRITE_TIMEOUT, "fail writes that take longer than this, 0 to disable")
+	flag.DurationVar(&HEARTBEAT_INTERVAL, "heartbeat", HEARTBEAT_INTERVAL, "interval between pings, 0 to disable")
+	flag.DurationVar(&IDLE_TIMEOUT, "idle-timeout", IDLE_TIMEOUT, "evict clients without activity for this long")
+	flag.DurationVar(&IDLE_WARNING, "idle-warning", IDLE_WARNING, "warn idle clients this long before eviction")
+	flag.IntVar(&MAX_CONNECTIONS_PER_IP, "max-conns-per-ip", MAX_CONNECTIONS_PER_IP, "connections allowed from one IP address, 0 for no limit")
+	allowedOrigins := flag.String("allowed-origins", "", "comma separated hosts whose pages may open a WebSocket, besides this server")
+	flag.Parse()
+
+	loaded, err := pokeconfig.Load(*configFile, "POKEMON_")
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if loaded != "" {
+		log.Printf("Loaded config from %s\n", loaded)
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if MANIFEST_FILE == "" {
+		MANIFEST_FILE = pokedata.ManifestPath(DATA_FILE)
+	}
+	for _, host := range strings.Split(*allowedOrigins, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			ALLOWED_ORIGINS = append(ALLOWED_ORIGINS, host)
+		}
+	}
+
+	if err := setCrawlScope(*crawlTypes, *crawlGenerations); err != nil {
+		log.Fatalf("Invalid crawl scope: %v", err)
+	}
+
+	_, err = ioutil.ReadFile(DATA_FILE)
+
+	if err != nil {
+		fmt.Printf("%s file is not exist\n", DATA_FILE)
+		fetchingData()
+	} else {
+		fmt.Printf("%s file is exist\n", DATA_FILE)
+	}
+
+	// Refuse to start on data that does not match its manifest, before
+	// anything reads it
+	dataset, err = pokedata.Verify(MANIFEST_FILE, DATA_FILE, pokedata.SchemaPokeAPI, REQUIRE_MANIFEST)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	if dataset.Version == "unversioned" {
+		log.Printf("Warning: %s not found, %s is unversioned\n", MANIFEST_FILE, DATA_FILE)
+	}
+
+	// Load Pokémon data from the data file
+	loadPokedex()
+	loadAccounts()
+	log.Printf("Loaded dataset %s (%s, created %s)\n", dataset.Version, dataset.CrawlerVersion, dataset.CreatedAt.Format(time.RFC3339))
+
+	// Both the TCP and the HTTP server read from this index
+	pokedexIndex = buildPokedexIndex(pokedex)
+	log.Printf("Indexed %d Pokemon of %d types\n", len(pokedexIndex.Entries), len(pokedexIndex.Types))
+
+	go evictIdleUsers()
+
+	// Serve cached sprites so clients never depend on the remote host
+	httpServer := &http.Server{Addr: HTTP_ADDR, Handler: newHTTPHandler()}
+	go serveHTTP(httpServer)
+
+	// Start TCP server
+	addr := TCP_ADDR
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	log.Printf("Server listening on %s\n", addr)
+
+	// Shut down cleanly on Ctrl+C or SIGTERM
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down\n", sig)
+		shutdown(listener, httpServer)
+		close(stopped)
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if isShuttingDown() {
+				break
+			}
+			log.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		go handleClient(&tcpClient{conn: conn})
+	}
+	<-stopped
+}
+
+func validateConfig() error {
+	switch {
+	case TCP_ADDR == "" || HTTP_ADDR == "":
+		return fmt.Errorf("-tcp-addr and -http-addr are required")
+	case TCP_ADDR == HTTP_ADDR:
+		return fmt.Errorf("-tcp-addr and -http-addr must differ")
+	case DATA_FILE == "":
+		return fmt.Errorf("-data is required")
+	case STORAGE != "file":
+		return fmt.Errorf("unsupported -storage %q, only \"file\" is available", STORAGE)
+	case ACCOUNTS_FILE == "" || PLAYER_DIR == "":
+		return fmt.Errorf("-accounts and -players are required")
+	case LIMIT_DATA < 0:
+		return fmt.Errorf("-limit must not be negative")
+	case TEAM_SIZE < 1:
+		return fmt.Errorf("-team-size must be at least 1")
+	case OFFER_SIZE < 0:
+		return fmt.Errorf("-offer-size must not be negative")
+	case OFFER_SIZE > 0 && OFFER_SIZE < TEAM_SIZE:
+		return fmt.Errorf("-offer-size must be at least -team-size")
+	case READ_TIMEOUT < 0 || WRITE_TIMEOUT < 0 || HEARTBEAT_INTERVAL < 0 || MAX_CONNECTIONS_PER_IP < 0:
+		return fmt.Errorf("timeouts and limits must not be negative")
+	case HEARTBEAT_INTERVAL > 0 && READ_TIMEOUT > 0 && READ_TIMEOUT <= HEARTBEAT_INTERVAL:
+		return fmt.Errorf("-read-timeout must be longer than -heartbeat")
+	case IDLE_WARNING <= 0 || IDLE_WARNING >= IDLE_TIMEOUT:
+		return fmt.Errorf("-idle-warning must be positive and shorter than -idle-timeout")
+	case SESSION_TTL <= 0 || SHUTDOWN_GRACE <= 0 || RECONNECT_GRACE <= 0:
+		return fmt.Errorf("-session-ttl, -shutdown-grace and -reconnect-grace must be positive")
+	}
+	return nil
+}
+
+// How long connected clients get to finish after the shutdown notice
+var SHUTDOWN_GRACE = 10 * time.Second
+
+var shutdownMu sync.Mutex
+var shuttingDown bool
+
+// Every running handleClient, TCP and WebSocket
+var activeClients sync.WaitGroup
+
+func isShuttingDown() bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	return shuttingDown
+}
+
+// beginClient counts a new client, unless the server is shutting down
+func beginClient() bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if shuttingDown {
+		return false
+	}
+	activeClients.Add(1)
+	return true
+}
+
+// shutdown stops accepting connections, tells connected clients, gives them
+// SHUTDOWN_GRACE to send their picks and then closes what is left
+func shutdown(listener net.Listener, httpServer *http.Server) {
+	shutdownMu.Lock()
+	shuttingDown = true
+	shutdownMu.Unlock()
+
+	listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_GRACE)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop HTTP server: %v", err)
+	}
+
+	// Battles are played by game.go, which drains them on its own shutdown.
+	// Clients still choosing can finish and have their picks saved.
+	online := onlineUsers()
+	for _, user := range online {
+		if user.conn == nil {
+			continue
+		}
+		if err := sendJSON(user.conn, ErrorResponse{Error: "server is shutting down"}); err != nil {
+			log.Printf("Failed to notify %s: %v", user.NAME, err)
+		}
+		// Idle clients have nothing left to save
+		if user.STATE == STATE_IDLE {
+			user.conn.Close()
+		}
+	}
+	log.Printf("Waiting for %d clients\n", len(online))
+
+	if !waitClients(SHUTDOWN_GRACE) {
+		// Closing the connections unblocks every pending read
+		for _, user := range onlineUsers() {
+			if user.conn != nil {
+				user.conn.Close()
+			}
+		}
+		waitClients(SHUTDOWN_GRACE)
+	}
+
+	// Accounts are saved on every change, write them once more to be safe
+	accountsMu.Lock()
+	if len(accounts) > 0 {
+		if err := saveAccounts(); err != nil {
+			log.Printf("Failed to save accounts: %v", err)
+		}
+	}
+	accountsMu.Unlock()
+
+	log.Println("Server stopped")
+}
+
+// waitClients reports whether every client finished within timeout
+func waitClients(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		activeClients.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func serveHTTP(httpServer *http.Server) {
+	log.Printf("HTTP server listening on %s\n", httpServer.Addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP server stopped: %v", err)
+	}
+}
+
+func newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/sprites/", http.StripPrefix("/sprites/", http.FileServer(http.Dir(SPRITE_DIR))))
+	mux.HandleFunc("/dataset", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, dataset)
+	}))
+	mux.HandleFunc("/pokemon", readOnly(handlePokemonList))
+	mux.HandleFunc("/pokemon/", readOnly(handlePokemon))
+	mux.HandleFunc("/types/", readOnly(handleTypeMatchups))
+	mux.HandleFunc("/players/", readOnly(handlePlayer))
+	mux.HandleFunc("/presence", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, onlineUsers())
+	}))
+
+	// Browser clients play over WebSocket with the same messages as TCP.
+	// Pages of other sites must not play with the visitor's session.
+	mux.Handle("/ws", websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			handleClient(&wsClient{conn: ws})
+		},
+	})
+
+	return mux
+}
+
+// checkOrigin accepts WebSocket handshakes without an Origin and from pages
+// of this server or of ALLOWED_ORIGINS
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	config.Origin = parsed
+	host := strings.ToLower(parsed.Host)
+	if host == strings.ToLower(r.Host) {
+		return nil
+	}
+	for _, allowed := range ALLOWED_ORIGINS {
+		if host == allowed || strings.ToLower(parsed.Hostname()) == allowed {
+			return nil
+		}
+	}
+	log.Printf("Refused WebSocket from origin %s\n", origin)
+	return fmt.Errorf("origin %q not allowed", origin)
+}
+
+// One Pokemon form in the index. Forms are separate entries with their own
+// types and stats.
+type PokedexEntry struct {
+	Key        string         `json:"key"`
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Species    string         `json:"species,omitempty"`
+	Form       string         `json:"form,omitempty"`
+	Generation int            `json:"generation,omitempty"`
+	Types      []string       `json:"types"`
+	Stats      map[string]int `json:"stats"`
+	ImagePath  string         `json:"image_path,omitempty"` // URL of the cached sprite on this server
+}
+
+type PokedexIndex struct {
+	Entries []PokedexEntry
+	ByKey   map[string]int // id/form key and lower case name to position in Entries
+	Types   map[string]Pokemon
+}
+
+var pokedexIndex PokedexIndex
+
+// buildPokedexIndex flattens the per-type lists of the data file into one
+// entry per Pokemon, sorted by id
+func buildPokedexIndex(data []Pokemon) PokedexIndex {
+	index := PokedexIndex{ByKey: make(map[string]int), Types: make(map[string]Pokemon)}
+
+	for _, pokemonType := range data {
+		index.Types[strings.ToLower(pokemonType.Name)] = pokemonType
+
+		for _, item := range pokemonType.POKEMON {
+			detail := item.POKEMON_DETAIL
+			key := pokemonKey(detail)
+			if _, ok := index.ByKey[key]; ok {
+				continue
+			}
+
+			entry := PokedexEntry{
+				Key:        key,
+				ID:         idFromURL(detail.URL),
+				Name:       detail.NAME,
+				Species:    detail.SPECIES.NAME,
+				Form:       detail.FORM,
+				Generation: detail.GENERATION,
+				Types:      []string{},
+				Stats:      make(map[string]int),
+			}
+			if detail.IMAGE_PATH != "" {
+				entry.ImagePath = "/sprites/" + path.Base(detail.IMAGE_PATH)
+			}
+			for _, t := range detail.TYPES {
+				entry.Types = append(entry.Types, t.TYPE.Name)
+			}
+			for _, stat := range detail.STATS {
+				entry.Stats[stat.Stat.Name] = stat.BaseStat
+			}
+			index.ByKey[key] = len(index.Entries)
+			index.Entries = append(index.Entries, entry)
+		}
+	}
+
+	sort.SliceStable(index.Entries, func(i, j int) bool { return index.Entries[i].ID < index.Entries[j].ID })
+	for i, entry := range index.Entries {
+		index.ByKey[entry.Key] = i
+		index.ByKey[strings.ToLower(entry.Name)] = i
+	}
+	return index
+}
+
+// Query parameter names for stats, e.g. min_sp_atk, to PokéAPI stat names
+var STAT_ALIASES = map[string]string{
+	"hp":              "hp",
+	"attack":          "attack",
+	"defense":         "defense",
+	"sp_atk":          "special-attack",
+	"sp_attack":       "special-attack",
+	"special_attack":  "special-attack",
+	"sp_def":          "special-defense",
+	"sp_defense":      "special-defense",
+	"special_defense": "special-defense",
+	"speed":           "speed",
+}
+
+const DEFAULT_PAGE_SIZE = 20
+const MAX_PAGE_SIZE = 100
+
+type PokemonPage struct {
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Results []PokedexEntry `json:"results"`
+}
+
+type TypeMatchups struct {
+	Type             string   `json:"type"`
+	DoubleDamageTo   []string `json:"double_damage_to"`
+	HalfDamageTo     []string `json:"half_damage_to"`
+	NoDamageTo       []string `json:"no_damage_to"`
+	DoubleDamageFrom []string `json:"double_damage_from"`
+	HalfDamageFrom   []string `json:"half_damage_from"`
+	NoDamageFrom     []string `json:"no_damage_from"`
+}
+
+// GET /pokemon?type=fire&generation=1&q=char&min_speed=80&page=1&per_page=20
+func handlePokemonList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := positiveParam(query.Get("page"), 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "page must be a positive number")
+		return
+	}
+	perPage, err := positiveParam(query.Get("per_page"), DEFAULT_PAGE_SIZE)
+	if err != nil || perPage > MAX_PAGE_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", MAX_PAGE_SIZE))
+		return
+	}
+
+	// Stat bounds from min_<stat> and max_<stat>
+	minStats := make(map[string]int)
+	maxStats := make(map[string]int)
+	for param, values := range query {
+		bounds := minStats
+		name := strings.TrimPrefix(param, "min_")
+		if strings.HasPrefix(param, "max_") {
+			bounds = maxStats
+			name = strings.TrimPrefix(param, "max_")
+		} else if name == param {
+			continue
+		}
+		stat, ok := STAT_ALIASES[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown stat %q", name))
+			return
+		}
+		value, err := strconv.Atoi(values[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a number", param))
+			return
+		}
+		bounds[stat] = value
+	}
+
+	var types []string
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			types = append(types, strings.ToLower(strings.TrimSpace(t)))
+		}
+	}
+	generation, _ := strconv.Atoi(query.Get("generation"))
+	search := strings.ToLower(query.Get("q"))
+
+	results := []PokedexEntry{}
+	for _, entry := range pokedexIndex.Entries {
+		if !hasAllTypes(entry, types) {
+			continue
+		}
+		if generation != 0 && entry.Generation != generation {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(entry.Name), search) {
+			continue
+		}
+		inBounds := true
+		for stat, min := range minStats {
+			inBounds = inBounds && entry.Stats[stat] >= min
+		}
+		for stat, max := range maxStats {
+			inBounds = inBounds && entry.Stats[stat] <= max
+		}
+		if inBounds {
+			results = append(results, entry)
+		}
+	}
+
+	response := PokemonPage{Total: len(results), Page: page, PerPage: perPage, Results: []PokedexEntry{}}
+	start := (page - 1) * perPage
+	if start < len(results) {
+		end := start + perPage
+		if end > len(results) {
+			end = len(results)
+		}
+		response.Results = results[start:end]
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /pokemon/{name}, /pokemon/{id} or /pokemon/{id}/{form}
+func handlePokemon(w http.ResponseWriter, r *http.Request) {
+	key := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/pokemon/"), "/"))
+	position, ok := pokedexIndex.ByKey[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("pokemon %q not found", key))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, pokedexIndex.Entries[position])
+}
+
+// GET /types/{name}/matchups
+func handleTypeMatchups(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/types/"), "/")
+	if rest != "matchups" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	pokemonType, ok := pokedexIndex.Types[strings.ToLower(name)]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("type %q not found", name))
+		return
+	}
+
+	relations := pokemonType.DAMAGE_RELATIONS
+	writeJSON(w, r, http.StatusOK, TypeMatchups{
+		Type:             pokemonType.Name,
+		DoubleDamageTo:   relationNames(relations.DOUBLE_DAMAGE_TO),
+		HalfDamageTo:     relationNames(relations.HALF_DAMAGE_TO),
+		NoDamageTo:       relationNames(relations.NO_DAMAGE_TO),
+		DoubleDamageFrom: relationNames(relations.DOUBLE_DAMAGE_FROM),
+		HalfDamageFrom:   relationNames(relations.HALF_DAMAGE_FROM),
+		NoDamageFrom:     relationNames(relations.NO_DAMAGE_FROM),
+	})
+}
+
+// GET /players/{name}
+func handlePlayer(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/players/")
+	if err := validateName(name); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid player name")
+		return
+	}
+
+	accountsMu.Lock()
+	account, exists := accounts[strings.ToLower(name)]
+	accountsMu.Unlock()
+
+	data, err := ioutil.ReadFile(playerFile(account.ID))
+	if !exists || err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("player %q not found", name))
+		return
+	}
+	var player PokemonOfUser
+	if err := json.Unmarshal(data, &player); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read player data")
+		return
+	}
+	player.Name = removeString(player.Name)
+	player.OwnerID = ""
+	writeJSON(w, r, http.StatusOK, player)
+}
+
+func hasAllTypes(entry PokedexEntry, types []string) bool {
+	for _, wanted := range types {
+		found := false
+		for _, t := range entry.Types {
+			found = found || t == wanted
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func relationNames(items []DamageRelationItem) []string {
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.NAME)
+	}
+	return names
+}
+
+func positiveParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return n, nil
+}
+
+// readOnly rejects everything but GET and HEAD
+func readOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writeJSON sends v with an ETag, answers If-None-Match with 304 and gzips
+// the body for clients that accept it
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+
+	// Weak, because the gzipped and plain bodies share it
+	etag := `W/"` + pokedata.SHA256Hex(body)[:32] + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("Content-Type", "application/json")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, strings.TrimPrefix(etag, "W/")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	gz := gzip.NewWriter(w)
+	gz.Write(body)
+	gz.Close()
+}
+
+// acceptsGzip reads an Accept-Encoding header: gzip, or * when gzip is not
+// listed, must be there with a quality above 0
+func acceptsGzip(header string) bool {
+	wildcard := false
+	for _, token := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(token, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(strings.ToLower(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			accepted = err == nil && q > 0
+		}
+		if coding == "gzip" {
+			return accepted
+		}
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// Largest JSON message a client may send
+const MAX_FRAME_SIZE = 1 << 20
+
+// A client that sends nothing, not even a pong, for this long is dropped
+var READ_TIMEOUT = 90 * time.Second
+
+// A write that takes longer than this fails
+var WRITE_TIMEOUT = 10 * time.Second
+
+// How often logged in clients are pinged
+var HEARTBEAT_INTERVAL = 30 * time.Second
+
+// Clients without any activity for this long are evicted, they are warned
+// IDLE_WARNING before
+var IDLE_TIMEOUT = 10 * time.Minute
+var IDLE_WARNING = time.Minute
+
+// Connections allowed from one IP address, 0 for no limit
+var MAX_CONNECTIONS_PER_IP = 5
+
+// Messages the server sends on its own, outside of a request
+type Event struct {
+	Event   string `json:"event"` // "ping", "idle_warning" or "evicted"
+	Message string `json:"message,omitempty"`
+}
+
+// ClientConn is a connected player. TCP and WebSocket clients exchange the
+// same JSON messages, only the framing differs, so handleClient serves both.
+type ClientConn interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(data []byte) error
+	RemoteAddr() string
+	Close() error
+}
+
+// On TCP every JSON message is framed by its int32 little endian length
+type tcpClient struct {
+	conn net.Conn
+}
+
+func (c *tcpClient) ReadFrame() ([]byte, error) {
+	if READ_TIMEOUT > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+	}
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("failed to read length: %w", err)
+	}
+	if length <= 0 || length > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("invalid frame length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return nil, fmt.Errorf("failed to read JSON data: %w", err)
+	}
+	return data, nil
+}
+
+func (c *tcpClient) WriteFrame(data []byte) error {
+	if WRITE_TIMEOUT > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	}
+	// Write the length of the JSON output first
+	if err := binary.Write(c.conn, binary.LittleEndian, int32(len(data))); err != nil {
+		return fmt.Errorf("failed to write length: %w", err)
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *tcpClient) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *tcpClient) Close() error {
+	return c.conn.Close()
+}
+
+// On WebSocket every message is one frame
+type wsClient struct {
+	conn *websocket.Conn
+}
+
+func (c *wsClient) ReadFrame() ([]byte, error) {
+	if READ_TIMEOUT > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+	}
+	var data []byte
+	if err := websocket.Message.Receive(c.conn, &data); err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("invalid frame length %d", len(data))
+	}
+	return data, nil
+}
+
+func (c *wsClient) WriteFrame(data []byte) error {
+	if WRITE_TIMEOUT > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	}
+	// JSON goes out as text messages so browsers get strings
+	return websocket.Message.Send(c.conn, string(data))
+}
+
+func (c *wsClient) RemoteAddr() string {
+	return c.conn.Request().RemoteAddr
+}
+
+func (c *wsClient) Close() error {
+	return c.conn.Close()
+}
+
+// Accounts are stored with bcrypt password hashes
+var ACCOUNTS_FILE = "accounts.json"
+
+// How long a session token can be used to reconnect
+var SESSION_TTL = 24 * time.Hour
+
+// Failed logins before the connection is closed
+const MAX_AUTH_ATTEMPTS = 3
+
+const MIN_PASSWORD_LENGTH = 6
+
+const MIN_NAME_LENGTH = 3
+const MAX_NAME_LENGTH = 20
+
+// Selected Pokémon are saved as <account id>.json in this directory
+var PLAYER_DIR = "players"
+
+type Account struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Session struct {
+	Token     string
+	AccountID string
+	Name      string
+	ExpiresAt time.Time
+}
+
+// Accounts by lower case name
+var accounts = make(map[string]Account)
+var accountsMu sync.Mutex
+
+// Sessions by token
+var sessions = make(map[string]Session)
+var sessionsMu sync.Mutex
+
+// First message of every connection
+type AuthRequest struct {
+	Action   string `json:"action"` // "register", "login" or "resume"
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+type AuthResponse struct {
+	OK        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+	AccountID string     `json:"account_id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Token     string     `json:"token,omitempty"`
+	Rules     *TeamRules `json:"rules,omitempty"`
+	Resumed   *Snapshot  `json:"resumed,omitempty"`
+}
+
+// Snapshot is what a reconnecting client gets back from its dropped session
+type Snapshot struct {
+	State string         `json:"state"`
+	Team  *PokemonOfUser `json:"team,omitempty"`
+}
+
+// TeamRules tell clients how to build a team
+type TeamRules struct {
+	TeamSize int  `json:"team_size"`
+	SameType bool `json:"same_type"`
+}
+
+func loadAccounts() {
+	data, err := ioutil.ReadFile(ACCOUNTS_FILE)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", ACCOUNTS_FILE, err)
+	}
+
+	var list []Account
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Fatalf("Failed to unmarshal %s: %v", ACCOUNTS_FILE, err)
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	for _, account := range list {
+		accounts[strings.ToLower(account.Name)] = account
+	}
+	log.Printf("Loaded %d accounts\n", len(accounts))
+}
+
+// saveAccounts writes all accounts, callers must hold accountsMu
+func saveAccounts() error {
+	list := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		list = append(list, account)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling accounts: %w", err)
+	}
+	// Write to a temporary file first so a crash never leaves half a file
+	if err := ioutil.WriteFile(ACCOUNTS_FILE+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("error writing accounts: %w", err)
+	}
+	return os.Rename(ACCOUNTS_FILE+".tmp", ACCOUNTS_FILE)
+}
+
+// validateName checks a player name against the allowed characters and length
+func validateName(name string) error {
+	if len(name) < MIN_NAME_LENGTH || len(name) > MAX_NAME_LENGTH {
+		return fmt.Errorf("name must be %d to %d characters", MIN_NAME_LENGTH, MAX_NAME_LENGTH)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return fmt.Errorf("name may only contain letters, digits, '_' and '-'")
+		}
+	}
+	return nil
+}
+
+func registerAccount(name, password string) (Account, error) {
+	name = strings.TrimSpace(name)
+	if err := validateName(name); err != nil {
+		return Account{}, err
+	}
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return Account{}, fmt.Errorf("password must be at least %d characters", MIN_PASSWORD_LENGTH)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if _, exists := accounts[strings.ToLower(name)]; exists {
+		return Account{}, fmt.Errorf("name %q is already taken", name)
+	}
+
+	account := Account{ID: randomToken(16), Name: name, PasswordHash: string(hash), CreatedAt: time.Now().UTC()}
+	accounts[strings.ToLower(name)] = account
+	if err := saveAccounts(); err != nil {
+		delete(accounts, strings.ToLower(name))
+		return Account{}, err
+	}
+	return account, nil
+}
+
+func loginAccount(name, password string) (Account, error) {
+	accountsMu.Lock()
+	account, exists := accounts[strings.ToLower(strings.TrimSpace(name))]
+	accountsMu.Unlock()
+
+	// Same answer for unknown names and wrong passwords
+	if !exists || bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return Account{}, fmt.Errorf("wrong name or password")
+	}
+	return account, nil
+}
+
+func newSession(account Account) Session {
+	session := Session{
+		Token:     randomToken(32),
+		AccountID: account.ID,
+		Name:      account.Name,
+		ExpiresAt: time.Now().Add(SESSION_TTL),
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	// Drop expired sessions while we are here
+	for token, s := range sessions {
+		if time.Now().After(s.ExpiresAt) {
+			delete(sessions, token)
+		}
+	}
+	sessions[session.Token] = session
+	return session
+}
+
+// resumeSession exchanges a valid token for a fresh one
+func resumeSession(token string) (Session, error) {
+	sessionsMu.Lock()
+	session, exists := sessions[token]
+	delete(sessions, token)
+	sessionsMu.Unlock()
+
+	if !exists || time.Now().After(session.ExpiresAt) {
+		return Session{}, fmt.Errorf("session expired, please log in again")
+	}
+	return newSession(Account{ID: session.AccountID, Name: session.Name}), nil
+}
+
+func randomToken(size int) string {
+	buffer := make([]byte, size)
+	if _, err := crand.Read(buffer); err != nil {
+		log.Panicf("Failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(buffer)
+}
+
+// authenticate handles register, login and resume requests until one
+// succeeds or the client runs out of attempts
+func authenticate(conn ClientConn) (Session, *User, error) {
+	for attempt := 1; attempt <= MAX_AUTH_ATTEMPTS; attempt++ {
+		data, err := conn.ReadFrame()
+		if err != nil {
+			return Session{}, nil, err
+		}
+
+		var request AuthRequest
+		var session Session
+		if err = json.Unmarshal(data, &request); err != nil {
+			err = fmt.Errorf("invalid request")
+		} else {
+			switch request.Action {
+			case "register":
+				var account Account
+				if account, err = registerAccount(request.Name, request.Password); err == nil {
+					session = newSession(account)
+				}
+			case "login":
+				var account Account
+				if account, err = loginAccount(request.Name, request.Password); err == nil {
+					session = newSession(account)
+				}
+			case "resume":
+				session, err = resumeSession(request.Token)
+			default:
+				err = fmt.Errorf("unknown action %q", request.Action)
+			}
+		}
+
+		response := AuthResponse{OK: err == nil}
+		var held *User
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			response.AccountID = session.AccountID
+			response.Name = session.Name
+			response.Token = session.Token
+			response.Rules = &TeamRules{TeamSize: TEAM_SIZE, SameType: TEAM_SAME_TYPE}
+
+			// Pick up where a dropped connection of this account left off
+			if held = reclaimUser(session.AccountID); held != nil {
+				response.Resumed = &Snapshot{State: held.heldState, Team: held.team}
+			}
+		}
+		if sendErr := sendJSON(conn, response); sendErr != nil {
+			return Session{}, nil, sendErr
+		}
+		if err == nil {
+			return session, held, nil
+		}
+	}
+	return Session{}, nil, fmt.Errorf("too many failed attempts")
+}
+
+func sendJSON(conn ClientConn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.WriteFrame(data)
+}
 
-var LIMIT_DATA int = 10
+func handleClient(conn ClientConn) {
+	defer conn.Close()
 
-type User struct {
-	ID string
-	NAME string
+	if !beginClient() {
+		sendJSON(conn, ErrorResponse{Error: "server is shutting down"})
+		return
+	}
+	defer activeClients.Done()
+
+	ip := remoteIP(conn.RemoteAddr())
+	if !acquireIP(ip) {
+		log.Printf("Too many connections from %s", ip)
+		sendJSON(conn, ErrorResponse{Error: "too many connections from your address"})
+		return
+	}
+	defer releaseIP(ip)
+
+	// A failing client must never take the server down with it
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Client %s failed: %v", conn.RemoteAddr(), r)
+		}
+	}()
+
+	// Every connection starts by logging in, registering or resuming
+	session, held, err := authenticate(conn)
+	if err != nil {
+		log.Printf("Failed to authenticate %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	// Register the client, a dropped connection is kept for RECONNECT_GRACE
+	tracked := &trackedConn{ClientConn: conn}
+	sessionID := addUser(session, tracked, held)
+	defer func() {
+		disconnectUser(sessionID)
+		printUsers()
+	}()
+	conn = tracked
+
+	// Keep the connection alive while the player is thinking
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go heartbeat(tracked, stopHeartbeat)
+
+	// Print the client's information and the list of all connected users
+	printUsers()
+
+	if held == nil || held.heldState == STATE_LOBBY {
+		// Send random Pokemon to client, the same ones again after a reconnect
+		offer := randomOffer(pokedex, OFFER_SIZE)
+		if held != nil && held.offer != nil {
+			offer = held.offer
+		}
+		setUserOffer(sessionID, offer)
+		sendOffer(offer, conn)
+
+		team, rejected := readPokemonOfUser(conn, session)
+		if team == nil {
+			// Only a dropped connection can resume, a rejected team ends it
+			if rejected {
+				dropUser(sessionID)
+			}
+			return
+		}
+		setUserTeam(sessionID, team)
+		setUserState(sessionID, STATE_IDLE)
+	}
+	if isShuttingDown() {
+		return
+	}
+
+	// handleStartGame(conn) 
+
+	if serveRequests(conn) {
+		dropUser(sessionID)
+	}
 }
 
-// Global slice to store connected users
-var users []User
-var mu sync.Mutex
+// Requests a client can send once its Pokémon are chosen
+type ClientRequest struct {
+	Action string `json:"action"` // "presence" or "quit"
+}
 
-type Pokemon struct {
-	ID               int              `json:"id"`
-	Name             string           `json:"name"`
-	DAMAGE_RELATIONS DamageRelation   `json:"damage_relations"`
-	POKEMON          []PokemonItem    `json:"pokemon"`
+type PresenceResponse struct {
+	Online []User `json:"online"`
 }
 
-type DamageRelation struct {
-	DOUBLE_DAMAGE_FROM []DamageRelationItem `json:"double_damage_from"`
-	DOUBLE_DAMAGE_TO   []DamageRelationItem `json:"double_damage_to"`
-	HALF_DAMAGE_FROM   []DamageRelationItem `json:"half_damage_from"`
-	HALF_DAMAGE_TO     []DamageRelationItem `json:"half_damage_to"`
+type ErrorResponse struct {
+	Error string `json:"error"`
 }
 
-type DamageRelationItem struct {
-	NAME string `json:"name"`
-	URL  string `json:"url"`
+// serveRequests answers client requests until the client disconnects and
+// reports whether it quit on purpose
+func serveRequests(conn ClientConn) bool {
+	for {
+		data, err := conn.ReadFrame()
+		if err != nil {
+			return false
+		}
+
+		var request ClientRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			sendJSON(conn, ErrorResponse{Error: "invalid request"})
+			continue
+		}
+
+		switch request.Action {
+		case "presence":
+			err = sendJSON(conn, PresenceResponse{Online: onlineUsers()})
+		case "quit":
+			return true
+		default:
+			err = sendJSON(conn, ErrorResponse{Error: fmt.Sprintf("unknown action %q", request.Action)})
+		}
+		if err != nil {
+			return false
+		}
+	}
 }
 
-type PokemonItem struct {
-	POKEMON_DETAIL PokemonDetail `json:"pokemon"`
+// trackedConn updates the user's last activity on every message it reads.
+// Writes are locked because shutdown notices come from another goroutine.
+type trackedConn struct {
+	ClientConn
+	sessionID string
+	writeMu   sync.Mutex
 }
 
-type PokemonDetail struct {
-	NAME  string         `json:"name"`
-	URL   string         `json:"url"`
-	STATS []PokemonStats `json:"stats"`
-    TYPES []PokemonType `json:"types"`
+func (c *trackedConn) WriteFrame(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ClientConn.WriteFrame(data)
 }
 
-type PokemonStats struct {
-	BaseStat int `json:"base_stat"`
-	Effort   int `json:"effort"`
-	Stat     struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
-	} `json:"stat"`
+// ReadFrame skips pongs, they keep the connection open but are not activity
+func (c *trackedConn) ReadFrame() ([]byte, error) {
+	for {
+		data, err := c.ClientConn.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		var request ClientRequest
+		if json.Unmarshal(data, &request) == nil && request.Action == "pong" {
+			continue
+		}
+		touchUser(c.sessionID)
+		return data, nil
+	}
 }
 
-type PokemonType struct {
-    TYPE struct {
-        Name string `json:"name"`
-		URL  string `json:"url"`
-    } `json:"type"`
+// heartbeat pings the client until stop is closed
+func heartbeat(conn *trackedConn, stop chan struct{}) {
+	if HEARTBEAT_INTERVAL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := sendJSON(conn, Event{Event: "ping"}); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
 }
 
-// User struct to store user information and their selected Pokémon
-type PokemonOfUser struct {
-	Name     string
-	TypeOfPokemon       string
-	Selected []PokemonDetail // Map to store selected Pokémon by type
+// evictIdleUsers warns and then disconnects users without activity
+func evictIdleUsers() {
+	interval := IDLE_WARNING / 2
+	if RECONNECT_GRACE/2 < interval {
+		interval = RECONNECT_GRACE / 2
+	}
+	for range time.Tick(interval) {
+		now := time.Now()
+		// Connections are copied under the lock, disconnectUser clears them
+		var warn, evict []idleConn
+
+		mu.Lock()
+		for id, user := range users {
+			// Dropped connections that did not come back in time are gone
+			if user.STATE == STATE_DISCONNECTED {
+				if now.Sub(user.disconnectedAt) >= RECONNECT_GRACE {
+					log.Printf("%s did not reconnect", user.NAME)
+					delete(users, id)
+				}
+				continue
+			}
+			if user.conn == nil {
+				continue
+			}
+			idle := now.Sub(user.LAST_ACTIVE)
+			if idle >= IDLE_TIMEOUT {
+				user.drop = true
+				evict = append(evict, idleConn{user.conn, user.NAME})
+			} else if idle >= IDLE_TIMEOUT-IDLE_WARNING && !user.warned {
+				user.warned = true
+				warn = append(warn, idleConn{user.conn, user.NAME})
+			}
+		}
+		mu.Unlock()
+
+		for _, idle := range warn {
+			message := fmt.Sprintf("you will be disconnected in %s without activity", IDLE_WARNING)
+			notifyIdle(idle, Event{Event: "idle_warning", Message: message}, false)
+		}
+		for _, idle := range evict {
+			log.Printf("Evicting idle user %s", idle.name)
+			notifyIdle(idle, Event{Event: "evicted", Message: "disconnected for inactivity"}, true)
+		}
+	}
 }
 
-type Lobby struct {
-	ID int
-	PLAYERS []PokemonOfUser
+// idleConn is the connection of an idle user as it was under the lock
+type idleConn struct {
+	conn ClientConn
+	name string
 }
 
-var lobby Lobby
+// notifyIdle sends an idle event, a failing connection must not stop the
+// evictor
+func notifyIdle(idle idleConn, event Event, close bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Failed to notify idle user %s: %v", idle.name, r)
+		}
+	}()
+	sendJSON(idle.conn, event)
+	if close {
+		idle.conn.Close()
+	}
+}
 
-func main() {
-	_, err := ioutil.ReadFile("pokedex.json")
+// Open connections by IP address
+var connectionsPerIP = make(map[string]int)
+var connectionsMu sync.Mutex
 
-	if err != nil {
-		fmt.Println("pokedex.json file is not exist")
-		fetchingData()
-	} else {
-		fmt.Println("pokedex.json file is exist")
+func acquireIP(ip string) bool {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	if MAX_CONNECTIONS_PER_IP > 0 && connectionsPerIP[ip] >= MAX_CONNECTIONS_PER_IP {
+		return false
+	}
+	connectionsPerIP[ip]++
+	return true
+}
 
-		// Load Pokémon data from pokedex.json
-    // loadPokedex()
+func releaseIP(ip string) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	connectionsPerIP[ip]--
+	if connectionsPerIP[ip] <= 0 {
+		delete(connectionsPerIP, ip)
 	}
+}
 
-	// Start TCP server
-	addr := ":8080"
-	listener, err := net.Listen("tcp", addr)
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		return addr
 	}
-	defer listener.Close()
-	log.Printf("Server listening on %s\n", addr)
+	return host
+}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
-			continue
-		}
-		go handleClient(conn)
+// addUser registers a new connection and returns its session ID. A held
+// user from a dropped connection keeps its ID and state.
+func addUser(session Session, conn *trackedConn, held *User) string {
+	now := time.Now().UTC()
+	user := &User{
+		conn:         conn,
+		ID:           randomToken(8),
+		ACCOUNT_ID:   session.AccountID,
+		NAME:         session.Name,
+		STATE:        STATE_LOBBY,
+		CONNECTED_AT: now,
+		LAST_ACTIVE:  now,
+	}
+	if held != nil {
+		user.ID = held.ID
+		user.STATE = held.heldState
+		user.CONNECTED_AT = held.CONNECTED_AT
+		user.offer = held.offer
+		user.team = held.team
 	}
-}
 
-func handleClient(conn net.Conn) {
-	defer conn.Close()
+	conn.sessionID = user.ID
+	mu.Lock()
+	defer mu.Unlock()
+	users[user.ID] = user
+	return user.ID
+}
 
-	// Read client name
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
-	if err != nil {
-		log.Printf("Failed to read client name: %v", err)
+// disconnectUser keeps the user for RECONNECT_GRACE, unless it was dropped
+func disconnectUser(sessionID string) {
+	mu.Lock()
+	defer mu.Unlock()
+	user, ok := users[sessionID]
+	if !ok {
+		return
+	}
+	if user.drop || isShuttingDown() {
+		delete(users, sessionID)
 		return
 	}
-	clientName := string(buffer[:n])
+	user.heldState = user.STATE
+	user.STATE = STATE_DISCONNECTED
+	user.disconnectedAt = time.Now()
+	user.conn = nil
+	log.Printf("%s disconnected, waiting %s for a reconnect", user.NAME, RECONNECT_GRACE)
+}
 
-	// Get the client's IP address
-	clientAddr := conn.RemoteAddr().String()
-	// fmt.Printf("Client %s connected from IP: %s\n", clientName , extractPort(clientAddr))
+// reclaimUser takes the held user of an account out of the registry
+func reclaimUser(accountID string) *User {
+	mu.Lock()
+	defer mu.Unlock()
+	for id, user := range users {
+		if user.ACCOUNT_ID == accountID && user.STATE == STATE_DISCONNECTED {
+			delete(users, id)
+			return user
+		}
+	}
+	return nil
+}
 
-	// Add the client's information to the global slice
+// dropUser makes the next disconnect final
+func dropUser(sessionID string) {
 	mu.Lock()
-	users = append(users, User{ID: extractPort(clientAddr), NAME: clientName})
-	mu.Unlock()
+	defer mu.Unlock()
+	if user, ok := users[sessionID]; ok {
+		user.drop = true
+	}
+}
 
-	// Print the client's information and the list of all connected users
-	printUsers()
+func setUserOffer(sessionID string, offer []Pokemon) {
+	mu.Lock()
+	defer mu.Unlock()
+	if user, ok := users[sessionID]; ok {
+		user.offer = offer
+	}
+}
 
-	trimValue := strings.TrimSpace(clientName)
+func setUserTeam(sessionID string, team *PokemonOfUser) {
+	mu.Lock()
+	defer mu.Unlock()
+	if user, ok := users[sessionID]; ok {
+		user.team = team
+		// The offer is only needed until the team is chosen
+		user.offer = nil
+	}
+}
+
+func touchUser(sessionID string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if user, ok := users[sessionID]; ok {
+		user.LAST_ACTIVE = time.Now().UTC()
+		user.warned = false
+	}
+}
 
-	// Send random 3 Pokemon to client
-	sendRandomPokemon(trimValue, conn)
+func setUserState(sessionID, state string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if user, ok := users[sessionID]; ok {
+		user.STATE = state
+		user.LAST_ACTIVE = time.Now().UTC()
+		user.warned = false
+	}
+}
 
-	readPokemonOfUser(conn)
+// onlineUsers returns a copy of the registry, oldest connection first
+func onlineUsers() []User {
+	mu.Lock()
+	defer mu.Unlock()
 
-	// handleStartGame(conn) 
+	online := make([]User, 0, len(users))
+	for _, user := range users {
+		online = append(online, *user)
+	}
+	sort.Slice(online, func(i, j int) bool { return online[i].CONNECTED_AT.Before(online[j].CONNECTED_AT) })
+	return online
 }
 
 // func handleStartGame(conn net.Conn) {
@@ -201,39 +1684,88 @@ func filterUsersByName(users []User, name string) []User {
 	return filteredUsers
 }
 
-func readPokemonOfUser(conn net.Conn) {
-	var length int32
-	err := binary.Read(conn, binary.LittleEndian, &length)
-	if err != nil {
-		fmt.Printf("failed to read length: %v", err)
-	}
-
-	userSent := make([]byte, length)
-	_, err = io.ReadFull(conn, userSent)
+// readPokemonOfUser reads and saves the team of the client. A team that is
+// rejected, not just lost with the connection, returns nil and true.
+func readPokemonOfUser(conn ClientConn, session Session) (*PokemonOfUser, bool) {
+	userSent, err := conn.ReadFrame()
 	if err != nil {
-		fmt.Printf("failed to read JSON data: %v", err)
+		fmt.Printf("failed to read selected Pokemon: %v\n", err)
+		return nil, false
 	}
 
 	var pokemonOfUser PokemonOfUser
 	err = json.Unmarshal(userSent, &pokemonOfUser)
 	if err != nil {
-		fmt.Printf("failed to unmarshal JSON data: %v", err)
+		fmt.Printf("failed to unmarshal JSON data: %v\n", err)
+		return nil, true
+	}
+
+	// The Pokémon belong to the logged in account, whatever name was sent
+	pokemonOfUser.Name = session.Name
+	pokemonOfUser.OwnerID = session.AccountID
+	fileName := playerFile(session.AccountID)
+
+	if err := validateTeam(pokemonOfUser); err != nil {
+		sendJSON(conn, SaveResponse{Error: err.Error()})
+		return nil, true
 	}
-	fileName := pokemonOfUser.Name + ".json"
-	// fmt.Println(fileName)
 
 	// Call the function to save the JSON to a file
-	err = saveUserPokemonFile(pokemonOfUser, removeString(fileName))
+	err = saveUserPokemonFile(pokemonOfUser, fileName)
 	if err != nil {
 			fmt.Println("Error:", err)
-			return
+			sendJSON(conn, SaveResponse{Error: "failed to save your team"})
+			return nil, true
+	}
+
+	fmt.Println("JSON data successfully written to file:", fileName)
+	sendJSON(conn, SaveResponse{OK: true})
+	return &pokemonOfUser, false
+}
+
+// Answer to a team sent by the client
+type SaveResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// validateTeam checks a team against TEAM_SIZE and TEAM_SAME_TYPE
+func validateTeam(team PokemonOfUser) error {
+	if len(team.Selected) != TEAM_SIZE {
+		return fmt.Errorf("a team has %d Pokemon, got %d", TEAM_SIZE, len(team.Selected))
 	}
+	if !TEAM_SAME_TYPE {
+		return nil
+	}
+
+	// Check against our own data, not the types the client sent
+	members := make(map[string]bool)
+	for _, pokemonType := range pokedex {
+		if strings.EqualFold(pokemonType.Name, team.TypeOfPokemon) {
+			for _, item := range pokemonType.POKEMON {
+				members[item.POKEMON_DETAIL.NAME] = true
+			}
+		}
+	}
+	for _, detail := range team.Selected {
+		if !members[detail.NAME] {
+			return fmt.Errorf("%s is not of type %s", detail.NAME, team.TypeOfPokemon)
+		}
+	}
+	return nil
+}
 
-	fmt.Println("JSON data successfully written to file:", removeString(fileName))
+// playerFile is keyed by the account ID, so names never reach the filesystem
+func playerFile(accountID string) string {
+	return filepath.Join(PLAYER_DIR, accountID+".json")
 }
 
 func saveUserPokemonFile(pokemon PokemonOfUser, filename string) error {
 	fmt.Println(filename)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+	}
+
 	// Marshal the struct to JSON
 	jsonData, err := json.Marshal(pokemon)
 	if err != nil {
@@ -255,55 +1787,46 @@ func saveUserPokemonFile(pokemon PokemonOfUser, filename string) error {
 	return nil
 }
 
-func sendRandomPokemon(clientName string, conn net.Conn) {
-	// Read the JSON file
-	data, err := ioutil.ReadFile("pokedex.json")
-	if err != nil {
-			fmt.Println("Error:", err)
-			return
-	}
-
-	// Define a variable to hold the decoded data
-	var pokemonData []Pokemon
-
-	// Unmarshal the JSON data into the struct
-	if err := json.Unmarshal(data, &pokemonData); err != nil {
-			fmt.Println("Error:", err)
-			return
-	}
-
-	// Encode Pokemon data into JSON bytes
-	jsonData, err := json.Marshal(pokemonData)
+func sendOffer(offer []Pokemon, conn ClientConn) {
+	// Encode the offered Pokemon into JSON bytes
+	jsonData, err := json.Marshal(offer)
 	if err != nil {
 			fmt.Println("Error:", err)
 			return
 	}
 
-	/*  */
-	// Write the length of the JSON output first
-	jsonLength := int32(len(jsonData))
-	err = binary.Write(conn, binary.LittleEndian, jsonLength)
-	if err != nil {
-		log.Fatalf("Failed to write length: %v", err)
-	}
-	/*  */
-
 	// Write Pokemon data to client
-	_, err = conn.Write(/* jsonOutput */jsonData)
+	err = conn.WriteFrame(jsonData)
 	if err != nil {
 		log.Printf("Failed to send Pokemon data to client: %v", err)
 		return
 	}
 }
 
+// randomOffer keeps up to size random Pokemon of every type, 0 keeps all
+func randomOffer(types []Pokemon, size int) []Pokemon {
+	if size == 0 {
+		return types
+	}
+	offer := make([]Pokemon, len(types))
+	for i, pokemonType := range types {
+		offer[i] = pokemonType
+		if len(pokemonType.POKEMON) <= size {
+			continue
+		}
+		offer[i].POKEMON = make([]PokemonItem, 0, size)
+		for _, j := range rand.Perm(len(pokemonType.POKEMON))[:size] {
+			offer[i].POKEMON = append(offer[i].POKEMON, pokemonType.POKEMON[j])
+		}
+	}
+	return offer
+}
+
 // Function to print the list of users beautifully
 func printUsers() {
-	mu.Lock()
-	defer mu.Unlock()
-
 	fmt.Println("Current connected users:")
-	for i, user := range users {
-		fmt.Printf("%d. Name: %s, IP: %s\n", i+1, user.NAME, user.ID)
+	for i, user := range onlineUsers() {
+		fmt.Printf("%d. Name: %s, State: %s, Connected: %s\n", i+1, user.NAME, user.STATE, user.CONNECTED_AT.Format(time.RFC3339))
 	}
 }
 
@@ -316,22 +1839,42 @@ func extractPort(address string) string {
 }
 
 func loadPokedex() {
-    file, err := os.Open("pokedex.json")
+    file, err := os.Open(DATA_FILE)
     if err != nil {
-        log.Fatalf("Failed to open pokedex.json: %v", err)
+        log.Fatalf("Failed to open %s: %v", DATA_FILE, err)
     }
     defer file.Close()
 
     data, err := ioutil.ReadAll(file)
     if err != nil {
-        log.Fatalf("Failed to read pokedex.json: %v", err)
+        log.Fatalf("Failed to read %s: %v", DATA_FILE, err)
     }
 
     if err := json.Unmarshal(data, &pokedex); err != nil {
-        log.Fatalf("Failed to unmarshal pokedex.json: %v", err)
+        log.Fatalf("Failed to unmarshal %s: %v", DATA_FILE, err)
+    }
+
+    // The crawlers write lists of species that decode without an error,
+    // but every PokéAPI type has an id and a name
+    for i, pokemonType := range pokedex {
+        if pokemonType.ID == 0 || pokemonType.Name == "" {
+            log.Fatalf("%s is not a list of PokéAPI types, entry %d has no id or name", DATA_FILE, i)
+        }
     }
 }
 
+// pokemonKey returns the id/form key of a Pokemon, e.g. "26" or "26/alola"
+func pokemonKey(detail PokemonDetail) string {
+	speciesID := idFromURL(detail.SPECIES.URL)
+	if speciesID == 0 {
+		speciesID = idFromURL(detail.URL)
+	}
+	if detail.FORM == "" {
+		return strconv.Itoa(speciesID)
+	}
+	return fmt.Sprintf("%d/%s", speciesID, detail.FORM)
+}
+
 func fetchGetPokemons(url string) Pokemon {
     // Make the GET request
     resp, err := http.Get(url)
@@ -346,6 +1889,7 @@ func fetchGetPokemons(url string) Pokemon {
     if err != nil {
         log.Fatalf("Failed to read response body: %v", err)
     }
+	fetchedSources.Record(url, body)
 
     // Parse the JSON response
     var typeResponse Pokemon
@@ -354,11 +1898,6 @@ func fetchGetPokemons(url string) Pokemon {
         log.Fatalf("Failed to unmarshal JSON: %v", err)
     }
 
-    // Limit the number of Pokemon items to the first three
-    if len(typeResponse.POKEMON) > LIMIT_DATA {
-        typeResponse.POKEMON = typeResponse.POKEMON[:LIMIT_DATA]
-    }
-
     return typeResponse
 }
 
@@ -375,6 +1914,7 @@ func fetchGetStatPokemon(pokemon PokemonItem) PokemonDetail {
     if err != nil {
         log.Fatalf("Failed to read response body: %v", err)
     }
+	fetchedSources.Record(pokemon.POKEMON_DETAIL.URL, pokemonBody)
 
     // Parse the JSON response to get stats
     var pokemonDetail PokemonDetail
@@ -387,23 +1927,53 @@ func fetchGetStatPokemon(pokemon PokemonItem) PokemonDetail {
 }
 
 func fetchingData() {
-	types := []string{"grass", "fire", "water"}
-
 	// Create a slice to store all the Pokemon data
 	var allPokemon []Pokemon
     
-	for _, item := range types {
+	for _, item := range CRAWL_TYPES {
 		url := fmt.Sprintf("https://pokeapi.co/api/v2/type/%s", item)
         typeResponse := fetchGetPokemons(url)
-        
-		// Loop through each Pokemon item
-		for index, pokemon := range typeResponse.POKEMON {
-            pokemonDetail := fetchGetStatPokemon(pokemon)
+
+		// Keep up to LIMIT_DATA Pokemon of the requested generations
+		var kept []PokemonItem
+		for _, pokemon := range typeResponse.POKEMON {
+			if LIMIT_DATA > 0 && len(kept) >= LIMIT_DATA {
+				break
+			}
+
+			// Default forms carry their species number in the URL, so most
+			// Pokemon can be skipped without fetching their details
+			if id := idFromURL(pokemon.POKEMON_DETAIL.URL); id < 10000 && !CRAWL_GENERATIONS.Contains(id) {
+				continue
+			}
+
+			pokemonDetail := fetchGetStatPokemon(pokemon)
+			generation := pokedata.GenerationOf(idFromURL(pokemonDetail.SPECIES.URL))
+			if !CRAWL_GENERATIONS.Has(generation) {
+				continue
+			}
 
 			// Append the stats to the Pokemon item
-			typeResponse.POKEMON[index].POKEMON_DETAIL.STATS = pokemonDetail.STATS
-            typeResponse.POKEMON[index].POKEMON_DETAIL.TYPES = pokemonDetail.TYPES
+			pokemon.POKEMON_DETAIL.STATS = pokemonDetail.STATS
+			pokemon.POKEMON_DETAIL.TYPES = pokemonDetail.TYPES
+			pokemon.POKEMON_DETAIL.ID = pokemonDetail.ID
+			pokemon.POKEMON_DETAIL.IS_DEFAULT = pokemonDetail.IS_DEFAULT
+			pokemon.POKEMON_DETAIL.SPECIES = pokemonDetail.SPECIES
+			pokemon.POKEMON_DETAIL.GENERATION = generation
+			if !pokemonDetail.IS_DEFAULT {
+				pokemon.POKEMON_DETAIL.FORM = strings.TrimPrefix(pokemon.POKEMON_DETAIL.NAME, pokemonDetail.SPECIES.NAME+"-")
+			}
+			if pokemonDetail.SPRITES != nil && pokemonDetail.SPRITES.FRONT_DEFAULT != "" {
+				imagePath, checksum, err := downloadSprite(pokemonDetail.SPRITES.FRONT_DEFAULT)
+				if err != nil {
+					log.Printf("Failed to cache the sprite of %s: %v", pokemon.POKEMON_DETAIL.NAME, err)
+				}
+				pokemon.POKEMON_DETAIL.IMAGE_PATH = imagePath
+				pokemon.POKEMON_DETAIL.IMAGE_SHA256 = checksum
+			}
+			kept = append(kept, pokemon)
 		}
+		typeResponse.POKEMON = kept
 
 		// Append the Pokemon data to the slice
 		allPokemon = append(allPokemon, typeResponse)
@@ -416,14 +1986,75 @@ func fetchingData() {
 	}
 
 	// Write JSON data to a file
-	err = ioutil.WriteFile("pokedex.json", allPokemonJSON, 0644)
+	err = ioutil.WriteFile(DATA_FILE, allPokemonJSON, 0644)
 	if err != nil {
 		log.Fatalf("Failed to write JSON to file: %v", err)
 	}
 
-	log.Println("All Pokemon data saved to pokedex.json")
+	log.Printf("All Pokemon data saved to %s\n", DATA_FILE)
 
-	loadPokedex()
+	// Record how the data was produced next to it
+	records := 0
+	for _, pokemonType := range allPokemon {
+		records += len(pokemonType.POKEMON)
+	}
+	file := pokedata.File{Name: DATA_FILE, SHA256: pokedata.SHA256Hex(allPokemonJSON), Records: records}
+	manifest := pokedata.NewManifest(pokedata.Producer("server.go"), pokedata.SchemaPokeAPI, []pokedata.File{file}, fetchedSources)
+	if err := manifest.Write(MANIFEST_FILE); err != nil {
+		log.Fatalf("Failed to write %s: %v", MANIFEST_FILE, err)
+	}
+}
+
+// downloadSprite stores an image in SPRITE_DIR under its content hash, like
+// sprites.go does for the crawler data, and returns its path and checksum
+func downloadSprite(url string) (string, string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	checksum := pokedata.SHA256Hex(data)
+	ext := path.Ext(path.Base(url))
+	if ext == "" {
+		ext = ".png"
+	}
+	imagePath := filepath.Join(SPRITE_DIR, checksum+ext)
+	if _, err := os.Stat(imagePath); err == nil {
+		return filepath.ToSlash(imagePath), checksum, nil
+	}
+	if err := os.MkdirAll(SPRITE_DIR, 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(imagePath, data, 0644); err != nil {
+		return "", "", err
+	}
+	return filepath.ToSlash(imagePath), checksum, nil
+}
+
+// setCrawlScope reads the type list ("all" or "fire,water") and the
+// generation range ("3" or "1-5") used by fetchingData
+func setCrawlScope(types string, generations string) error {
+	var err error
+	if CRAWL_TYPES, err = pokedata.ParseTypes(types); err != nil {
+		return err
+	}
+	CRAWL_GENERATIONS, err = pokedata.ParseGenerations(generations)
+	return err
+}
+
+// idFromURL reads the id out of a PokéAPI url like .../pokemon/25/
+func idFromURL(url string) int {
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	id, _ := strconv.Atoi(parts[len(parts)-1])
+	return id
 }
 
 func formatDataReadable(data any) any {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"pokemonproject/server/pokedata"
+)
+
+// sprites.go downloads the image_url of every Pokémon in pokedex.json into
+// spriteDir, names each file after the SHA-256 of its content and writes the
+// local path and checksum back into pokedex.json. The game server serves
+// spriteDir under /sprites/ so clients never need the remote host.
+
+const (
+	pokedexFile = "pokedex.json"
+	spriteDir   = "sprites"
+)
+
+func main() {
+	data, err := os.ReadFile(pokedexFile)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", pokedexFile, err)
+	}
+
+	var pokemons []pokedata.Pokemon
+	if err := json.Unmarshal(data, &pokemons); err != nil {
+		log.Fatalf("Failed to unmarshal %s: %v", pokedexFile, err)
+	}
+
+	if err := os.MkdirAll(spriteDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", spriteDir, err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	cached, downloaded, failed := 0, 0, 0
+	for i := range pokemons {
+		pokemon := &pokemons[i]
+		if pokemon.ImageURL == "" {
+			continue
+		}
+
+		// Keep the cached file if it still matches its checksum
+		if spriteIsCached(pokemon.ImagePath, pokemon.ImageSHA256) {
+			cached++
+			continue
+		}
+
+		imagePath, checksum, err := downloadSprite(client, pokemon.ImageURL)
+		if err != nil {
+			fmt.Printf("Error caching sprite for %s: %v\n", pokemon.Name, err)
+			failed++
+			continue
+		}
+		pokemon.ImagePath = imagePath
+		pokemon.ImageSHA256 = checksum
+		downloaded++
+	}
+
+	jsonData, err := json.MarshalIndent(pokemons, "", "  ")
+	if err != nil {
+		log.Fatalf("Error marshalling JSON: %v", err)
+	}
+	if err := os.WriteFile(pokedexFile, jsonData, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", pokedexFile, err)
+	}
+
+	// pokedex.json changed, so the manifest needs its new hash
+	if err := updateManifest(jsonData); err != nil {
+		log.Fatalf("Error updating %s: %v", pokedata.ManifestPath(pokedexFile), err)
+	}
+
+	fmt.Printf("Sprites: %d downloaded, %d already cached, %d failed\n", downloaded, cached, failed)
+}
+
+// updateManifest records the new hash of pokedex.json and derives a new
+// dataset version from it. Without a manifest there is nothing to update.
+func updateManifest(pokedexData []byte) error {
+	manifest, err := pokedata.ReadManifest(pokedata.ManifestPath(pokedexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	manifest.UpdateFile(pokedexFile, pokedexData)
+	return manifest.Write(pokedata.ManifestPath(pokedexFile))
+}
+
+// spriteIsCached reports whether imagePath exists and hashes to checksum
+func spriteIsCached(imagePath, checksum string) bool {
+	if imagePath == "" || checksum == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.FromSlash(imagePath))
+	if err != nil {
+		return false
+	}
+	return pokedata.SHA256Hex(data) == checksum
+}
+
+// downloadSprite stores the image under its content hash and returns the
+// local path (relative to the server directory) and the checksum.
+func downloadSprite(client *http.Client, url string) (string, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s: %w", url, err)
+	}
+
+	checksum := pokedata.SHA256Hex(data)
+
+	ext := path.Ext(path.Base(url))
+	if ext == "" {
+		ext = ".png"
+	}
+	imagePath := path.Join(spriteDir, checksum+ext)
+
+	// Same content means same file, so an existing file can be reused
+	if _, err := os.Stat(filepath.FromSlash(imagePath)); err == nil {
+		return imagePath, checksum, nil
+	}
+
+	tmp := filepath.FromSlash(imagePath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, filepath.FromSlash(imagePath)); err != nil {
+		return "", "", fmt.Errorf("failed to store %s: %w", imagePath, err)
+	}
+
+	return imagePath, checksum, nil
+}